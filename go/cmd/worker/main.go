@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os/signal"
+	"syscall"
 
 	worker "github.com/FatsharkStudiosAB/haja-workers/go"
 	"github.com/FatsharkStudiosAB/haja-workers/go/cmd/worker/examples"
@@ -25,9 +28,13 @@ func main() {
 	server.RegisterFunction(examples.InputFunction())
 	server.RegisterFunction(examples.StoreChatHistoryFunction())
 
-	// Start server (this will handle all initialization and block forever)
+	// Stop gracefully on SIGINT/SIGTERM so in-flight requests can drain
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Start server (this will handle all initialization and block until shutdown)
 	log.Println("Starting server with SDK...")
-	if err := server.Start(); err != nil {
+	if err := server.StartContext(ctx); err != nil {
 		log.Fatal("Server failed:", err)
 	}
 }
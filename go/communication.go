@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
+)
+
+// WorkflowCommunicator carries events between a worker and the workflow server
+type WorkflowCommunicator = communication.WorkflowCommunicator
+
+// ConnectionState describes the connection to the workflow server
+type ConnectionState = communication.ConnectionState
+
+// Connection states reported by WorkflowCommunicator.State and SubscribeState
+const (
+	StateConnecting   = communication.StateConnecting
+	StateConnected    = communication.StateConnected
+	StateReconnecting = communication.StateReconnecting
+	StateAuthFailed   = communication.StateAuthFailed
+	StateClosed       = communication.StateClosed
+)
+
+// OutboundPolicy decides what happens to an event sent while disconnected
+type OutboundPolicy = communication.OutboundPolicy
+
+// Outbound policies, see WithOutboundPolicy
+const (
+	PolicyReject = communication.PolicyReject
+	PolicyQueue  = communication.PolicyQueue
+	PolicyDrop   = communication.PolicyDrop
+)
+
+// DefaultOutboundQueueSize is the number of events held while disconnected by default
+const DefaultOutboundQueueSize = communication.DefaultOutboundQueueSize
+
+// MemoryCommunicator is an in-process WorkflowCommunicator without network sockets
+type MemoryCommunicator = communication.MemoryCommunicator
+
+// NewMemoryCommunicator creates a standalone in-memory communicator. Events sent by the
+// worker appear on Outbound, events passed to Inject are received by the worker.
+func NewMemoryCommunicator(buffer int) *MemoryCommunicator {
+	return communication.NewMemoryCommunicator(buffer)
+}
+
+// NewMemoryPair creates two connected in-memory communicators: whatever one side sends,
+// the other receives
+func NewMemoryPair(buffer int) (*MemoryCommunicator, *MemoryCommunicator) {
+	return communication.NewMemoryPair(buffer)
+}
+
+// TokenSource supplies the API token attached to the event stream. Sources that can
+// replace a rejected token also implement Invalidate().
+type TokenSource = communication.TokenSource
+
+// Token is an API token and its expiry; a zero Expiry never expires
+type Token = communication.Token
+
+// FileTokenSource reads the API token from a file and re-reads it when the file changes
+type FileTokenSource = communication.FileTokenSource
+
+// OAuth2ClientCredentials fetches API tokens with the OAuth2 client credentials grant
+type OAuth2ClientCredentials = communication.OAuth2ClientCredentials
+
+// NewStaticTokenSource returns a source for a fixed API token
+func NewStaticTokenSource(token string) TokenSource {
+	return communication.NewStaticTokenSource(token)
+}
+
+// NewFileTokenSource returns a source reading the API token from path
+func NewFileTokenSource(path string) *FileTokenSource {
+	return communication.NewFileTokenSource(path)
+}
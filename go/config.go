@@ -2,6 +2,9 @@ package worker
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the configuration for the SDK server
@@ -14,13 +17,50 @@ type Config struct {
 	GrpcServerAddress string // Address of the gRPC workflow server
 	ServerApiToken    string // API token for authentication
 
+	// Token sources replacing the static ServerApiToken, in order of precedence
+	TokenSource        TokenSource // Custom source of the API token
+	OAuthTokenURL      string      // OAuth2 client credentials token endpoint
+	OAuthClientID      string
+	OAuthClientSecret  string
+	OAuthScopes        []string
+	ServerApiTokenFile string // File holding the API token, re-read when it changes
+
+	// TLS configuration for the gRPC connection
+	GrpcTLS           bool   // Enable TLS; the server is verified against the system roots unless GrpcTLSCAFile is set
+	GrpcTLSCAFile     string // PEM bundle of trusted CAs
+	GrpcTLSCertFile   string // PEM client certificate for mutual TLS
+	GrpcTLSKeyFile    string // PEM client key for mutual TLS
+	GrpcTLSServerName string // Overrides the host name used to verify the server certificate
+
+	// Events sent while disconnected from the workflow server
+	OutboundQueueSize int                       // Maximum number of queued events; 0 disables queueing
+	OutboundQueueDir  string                    // Persist queued events in this directory to survive restarts
+	OutboundPolicies  map[string]OutboundPolicy // Per event type overrides of DefaultOutboundPolicies
+
 	// Handler/dispatcher configuration
 	HandlersConcurrency  int
 	IncomingEventsBuffer int
 
-	// gRPC lifecycle configuration
-	GrpcReconnectIntervalSec   int
-	GrpcHealthcheckIntervalSec int
+	// gRPC lifecycle configuration. Reconnection waits a random delay of up to
+	// GrpcReconnectIntervalSec, growing by GrpcReconnectMultiplier per failed attempt
+	// up to GrpcReconnectMaxIntervalSec.
+	GrpcReconnectIntervalSec    int
+	GrpcReconnectMaxIntervalSec int
+	GrpcReconnectMultiplier     float64
+	GrpcHealthcheckIntervalSec  int
+
+	// HeartbeatInterval is how often the worker reports its capacity (0 disables heartbeats)
+	HeartbeatInterval time.Duration
+
+	// ShutdownTimeout bounds how long StartContext waits for in-flight handlers
+	// to finish once its context is cancelled
+	ShutdownTimeout time.Duration
+
+	// MaxFunctionPanics disables a function after this many consecutive panics (0 never disables)
+	MaxFunctionPanics int
+
+	// Communicator replaces the gRPC connection to the workflow server when set
+	Communicator WorkflowCommunicator
 }
 
 // Option is a functional option for configuring the SDK
@@ -33,22 +73,60 @@ func defaultConfig() *Config {
 	communicationMode := getEnvWithDefault("COMMUNICATION_MODE", "grpc")
 	grpcServerAddress := getEnvWithDefault("GRPC_SERVER_ADDRESS", "localhost:50051")
 	serverApiToken := getEnvWithDefault("SERVER_API_TOKEN", "")
+	serverApiTokenFile := getEnvWithDefault("SERVER_API_TOKEN_FILE", "")
+	oauthTokenURL := getEnvWithDefault("OAUTH_TOKEN_URL", "")
+	oauthClientID := getEnvWithDefault("OAUTH_CLIENT_ID", "")
+	oauthClientSecret := getEnvWithDefault("OAUTH_CLIENT_SECRET", "")
+	oauthScopes := strings.Fields(strings.ReplaceAll(os.Getenv("OAUTH_SCOPES"), ",", " "))
+	grpcTLSCAFile := getEnvWithDefault("GRPC_TLS_CA_FILE", "")
+	grpcTLSCertFile := getEnvWithDefault("GRPC_TLS_CERT_FILE", "")
+	grpcTLSKeyFile := getEnvWithDefault("GRPC_TLS_KEY_FILE", "")
+	grpcTLSServerName := getEnvWithDefault("GRPC_TLS_SERVER_NAME", "")
+	// Any TLS file or name implies TLS unless GRPC_TLS says otherwise
+	grpcTLS := grpcTLSCAFile != "" || grpcTLSCertFile != "" || grpcTLSServerName != ""
+	if enabled, err := strconv.ParseBool(os.Getenv("GRPC_TLS")); err == nil {
+		grpcTLS = enabled
+	}
+	outboundQueueSize := getEnvInt("OUTBOUND_QUEUE_SIZE", DefaultOutboundQueueSize)
+	outboundQueueDir := getEnvWithDefault("OUTBOUND_QUEUE_DIR", "")
 	// Defaults mirror current hard-coded behavior
 	handlersConcurrency := 8
 	incomingBuffer := 100
-	reconnectInterval := 5
+	reconnectInterval := getEnvInt("GRPC_RECONNECT_INTERVAL_SEC", 5)
+	reconnectMaxInterval := getEnvInt("GRPC_RECONNECT_MAX_INTERVAL_SEC", 60)
+	reconnectMultiplier := 2.0
 	healthcheckInterval := 30
+	heartbeatInterval := time.Duration(getEnvInt("HEARTBEAT_INTERVAL_SEC", 10)) * time.Second
+	shutdownTimeout := 30 * time.Second
+	maxFunctionPanics := 5
 
 	return &Config{
-		ServerName:                 serverName,
-		CodexEnvPath:               codexEnvPath,
-		CommunicationMode:          communicationMode,
-		GrpcServerAddress:          grpcServerAddress,
-		ServerApiToken:             serverApiToken,
-		HandlersConcurrency:        handlersConcurrency,
-		IncomingEventsBuffer:       incomingBuffer,
-		GrpcReconnectIntervalSec:   reconnectInterval,
-		GrpcHealthcheckIntervalSec: healthcheckInterval,
+		ServerName:                  serverName,
+		CodexEnvPath:                codexEnvPath,
+		CommunicationMode:           communicationMode,
+		GrpcServerAddress:           grpcServerAddress,
+		ServerApiToken:              serverApiToken,
+		ServerApiTokenFile:          serverApiTokenFile,
+		OAuthTokenURL:               oauthTokenURL,
+		OAuthClientID:               oauthClientID,
+		OAuthClientSecret:           oauthClientSecret,
+		OAuthScopes:                 oauthScopes,
+		GrpcTLS:                     grpcTLS,
+		GrpcTLSCAFile:               grpcTLSCAFile,
+		GrpcTLSCertFile:             grpcTLSCertFile,
+		GrpcTLSKeyFile:              grpcTLSKeyFile,
+		GrpcTLSServerName:           grpcTLSServerName,
+		OutboundQueueSize:           outboundQueueSize,
+		OutboundQueueDir:            outboundQueueDir,
+		HandlersConcurrency:         handlersConcurrency,
+		IncomingEventsBuffer:        incomingBuffer,
+		GrpcReconnectIntervalSec:    reconnectInterval,
+		GrpcReconnectMaxIntervalSec: reconnectMaxInterval,
+		GrpcReconnectMultiplier:     reconnectMultiplier,
+		GrpcHealthcheckIntervalSec:  healthcheckInterval,
+		HeartbeatInterval:           heartbeatInterval,
+		ShutdownTimeout:             shutdownTimeout,
+		MaxFunctionPanics:           maxFunctionPanics,
 	}
 }
 
@@ -80,6 +158,60 @@ func WithServerApiToken(token string) Option {
 	return func(c *Config) { c.ServerApiToken = token }
 }
 
+// WithServerApiTokenFile reads the API token from a file, e.g. a mounted secret.
+// The file is re-read when it changes.
+func WithServerApiTokenFile(path string) Option {
+	return func(c *Config) { c.ServerApiTokenFile = path }
+}
+
+// WithOAuthClientCredentials fetches API tokens from an OAuth2 token endpoint with the
+// client credentials grant. Tokens are refreshed before they expire.
+func WithOAuthClientCredentials(tokenURL, clientID, clientSecret string, scopes ...string) Option {
+	return func(c *Config) {
+		c.OAuthTokenURL = tokenURL
+		c.OAuthClientID = clientID
+		c.OAuthClientSecret = clientSecret
+		c.OAuthScopes = scopes
+	}
+}
+
+// WithTokenSource supplies the API token from a custom source
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Config) { c.TokenSource = source }
+}
+
+// WithGrpcTLS enables TLS for the gRPC connection, verifying the server against the system roots
+func WithGrpcTLS() Option {
+	return func(c *Config) { c.GrpcTLS = true }
+}
+
+// WithGrpcTLSCAFile enables TLS and verifies the server against the CAs in the PEM file.
+// The file is re-read when it changes.
+func WithGrpcTLSCAFile(path string) Option {
+	return func(c *Config) {
+		c.GrpcTLS = true
+		c.GrpcTLSCAFile = path
+	}
+}
+
+// WithGrpcClientCertificate enables mutual TLS with the given PEM certificate and key.
+// Rotated files are picked up on the next handshake.
+func WithGrpcClientCertificate(certFile, keyFile string) Option {
+	return func(c *Config) {
+		c.GrpcTLS = true
+		c.GrpcTLSCertFile = certFile
+		c.GrpcTLSKeyFile = keyFile
+	}
+}
+
+// WithGrpcTLSServerName enables TLS and overrides the host name the server certificate is verified against
+func WithGrpcTLSServerName(name string) Option {
+	return func(c *Config) {
+		c.GrpcTLS = true
+		c.GrpcTLSServerName = name
+	}
+}
+
 // WithGrpcMode configures the SDK to use gRPC communication
 func WithGrpcMode(serverAddress string) Option {
 	return func(c *Config) {
@@ -88,6 +220,60 @@ func WithGrpcMode(serverAddress string) Option {
 	}
 }
 
+// WithGrpcReconnectBackoff sets the reconnection backoff: the first delay is at most
+// initialSec seconds, and the bound grows by multiplier per failed attempt up to maxSec
+func WithGrpcReconnectBackoff(initialSec, maxSec int, multiplier float64) Option {
+	return func(c *Config) {
+		c.GrpcReconnectIntervalSec = initialSec
+		c.GrpcReconnectMaxIntervalSec = maxSec
+		c.GrpcReconnectMultiplier = multiplier
+	}
+}
+
+// WithOutboundQueue sets how many events are held while disconnected and, when dir is
+// not empty, persists them there so they are sent after a restart. A size of 0 rejects
+// every event sent while disconnected.
+func WithOutboundQueue(size int, dir string) Option {
+	return func(c *Config) {
+		c.OutboundQueueSize = size
+		c.OutboundQueueDir = dir
+	}
+}
+
+// WithOutboundPolicy sets whether events of the given type are queued, dropped or
+// rejected while disconnected
+func WithOutboundPolicy(event string, policy OutboundPolicy) Option {
+	return func(c *Config) {
+		if c.OutboundPolicies == nil {
+			c.OutboundPolicies = make(map[string]OutboundPolicy)
+		}
+		c.OutboundPolicies[event] = policy
+	}
+}
+
+// WithHeartbeatInterval sets how often the worker sends a heartbeat with its capacity.
+// A non-positive interval disables heartbeats.
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(c *Config) { c.HeartbeatInterval = interval }
+}
+
+// WithShutdownTimeout sets how long a graceful shutdown waits for in-flight handlers
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(c *Config) { c.ShutdownTimeout = timeout }
+}
+
+// WithMaxFunctionPanics sets after how many consecutive panics a function is disabled.
+// A value of 0 keeps panicking functions registered.
+func WithMaxFunctionPanics(n int) Option {
+	return func(c *Config) { c.MaxFunctionPanics = n }
+}
+
+// WithCommunicator makes the server talk through comm instead of dialing the gRPC
+// workflow server, e.g. a MemoryCommunicator in tests or in-process pipelines
+func WithCommunicator(comm WorkflowCommunicator) Option {
+	return func(c *Config) { c.Communicator = comm }
+}
+
 // Redis mode removed
 
 // Redis group removed
@@ -106,6 +292,24 @@ func (c *Config) applyToEnvironment() {
 	}
 }
 
+// tokenSource returns the configured token source, or nil to use ServerApiToken
+func (c *Config) tokenSource() TokenSource {
+	switch {
+	case c.TokenSource != nil:
+		return c.TokenSource
+	case c.OAuthTokenURL != "":
+		return &OAuth2ClientCredentials{
+			TokenURL:     c.OAuthTokenURL,
+			ClientID:     c.OAuthClientID,
+			ClientSecret: c.OAuthClientSecret,
+			Scopes:       c.OAuthScopes,
+		}
+	case c.ServerApiTokenFile != "":
+		return NewFileTokenSource(c.ServerApiTokenFile)
+	}
+	return nil
+}
+
 // getEnvWithDefault returns the environment variable value or a default if not set
 func getEnvWithDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -113,3 +317,11 @@ func getEnvWithDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt returns the environment variable as an integer, or a default if it is not a number
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+// Error is the structured error returned to callers of a function.
+// Return one from a handler to control the error code, retryability and details
+// the caller receives; RpcClient.Call returns the same type for remote failures.
+type Error = types.Error
+
+// ErrorCode classifies an Error
+type ErrorCode = types.ErrorCode
+
+// Error codes understood by the workflow engine
+const (
+	ErrorCodeInvalidInput = types.ErrorCodeInvalidInput
+	ErrorCodeNotFound     = types.ErrorCodeNotFound
+	ErrorCodeTimeout      = types.ErrorCodeTimeout
+	ErrorCodeInternal     = types.ErrorCodeInternal
+	ErrorCodeUnavailable  = types.ErrorCodeUnavailable
+	ErrorCodeCancelled    = types.ErrorCodeCancelled
+	ErrorCodeOverloaded   = types.ErrorCodeOverloaded
+)
+
+// NewError creates an Error. Timeouts, unavailability and overload are retryable by default.
+func NewError(code ErrorCode, message string) *Error {
+	return types.NewError(code, message)
+}
+
+// Errorf creates an Error with a formatted message
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return types.Errorf(code, format, args...)
+}
+
+// AsError returns the first *Error in err's chain, or nil if there is none
+func AsError(err error) *Error {
+	return types.AsError(err)
+}
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"time"
 
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
@@ -19,8 +20,12 @@ type Function[In any, Out any] struct {
 	version     string
 	description string
 	handler     func(In, *types.EventMessage, *state.GlobalState) (Out, error)
+	ctxHandler  func(context.Context, In, *types.EventMessage, *state.GlobalState) (Out, error)
 	tags        []string
 	ttl         time.Duration
+	timeout     time.Duration
+	concurrency int
+	validation  inputValidation
 }
 
 // NewFunction creates a new function builder with the specified name, version, and description
@@ -38,6 +43,16 @@ func NewFunction[In any, Out any](name, version, description string) *Function[I
 // The handler receives the typed input, event message, and global state
 func (f *Function[In, Out]) WithHandler(handler func(In, *types.EventMessage, *state.GlobalState) (Out, error)) *Function[In, Out] {
 	f.handler = handler
+	f.ctxHandler = nil
+	return f
+}
+
+// WithContextHandler sets a context-aware function handler, replacing any handler set with WithHandler.
+// The context is cancelled when the workflow cancels the run (function_cancel event),
+// so long-running work such as LLM calls should pass it on.
+func (f *Function[In, Out]) WithContextHandler(handler func(context.Context, In, *types.EventMessage, *state.GlobalState) (Out, error)) *Function[In, Out] {
+	f.ctxHandler = handler
+	f.handler = nil
 	return f
 }
 
@@ -53,14 +68,46 @@ func (f *Function[In, Out]) WithCacheTTL(ttl time.Duration) *Function[In, Out] {
 	return f
 }
 
+// WithTimeout limits how long a single execution may run. When the limit (or an earlier
+// deadline sent by the caller) expires, the handler's context is cancelled and the caller
+// receives a timeout error. A value of 0 disables the limit.
+func (f *Function[In, Out]) WithTimeout(timeout time.Duration) *Function[In, Out] {
+	f.timeout = timeout
+	return f
+}
+
+// WithMaxConcurrency limits how many executions of this function run at once, so a slow
+// function cannot occupy every handler. Requests over the limit wait without holding a
+// handler slot. A value of 0 disables the limit.
+func (f *Function[In, Out]) WithMaxConcurrency(n int) *Function[In, Out] {
+	f.concurrency = n
+	return f
+}
+
+// AllowUnknownFields accepts inputs containing fields that are not part of the input type.
+// By default such inputs are rejected with an invalid_input error.
+func (f *Function[In, Out]) AllowUnknownFields() *Function[In, Out] {
+	f.validation.allowUnknownFields = true
+	return f
+}
+
+// WithoutInputValidation passes inputs to the handler without checking them against the input schema
+func (f *Function[In, Out]) WithoutInputValidation() *Function[In, Out] {
+	f.validation.disabled = true
+	return f
+}
+
 // Build creates the actual function implementation that satisfies basefunction.FunctionInterface
 func (f *Function[In, Out]) Build(gs *state.GlobalState) basefunction.FunctionInterface {
-	bf := basefunction.NewFunction(
+	bf := basefunction.NewFunctionWithContext(
 		f.name,
 		f.version,
 		f.description,
-		func(inputs In, eventState *types.EventMessage) (Out, error) {
+		func(ctx context.Context, inputs In, eventState *types.EventMessage) (Out, error) {
 			// Call the user's handler with the global state for advanced use cases
+			if f.ctxHandler != nil {
+				return f.ctxHandler(ctx, inputs, eventState, gs)
+			}
 			return f.handler(inputs, eventState, gs)
 		},
 		f.tags,
@@ -72,6 +119,10 @@ func (f *Function[In, Out]) Build(gs *state.GlobalState) basefunction.FunctionIn
 		fn.SetCacheTTL(f.ttl)
 	}
 
+	bf.SetTimeout(f.timeout)
+	bf.SetMaxConcurrency(f.concurrency)
+	f.validation.apply(bf)
+
 	return bf
 }
 
@@ -81,7 +132,11 @@ type SimpleFunction[In any, Out any] struct {
 	version     string
 	description string
 	handler     func(In) (Out, error)
+	ctxHandler  func(context.Context, In) (Out, error)
 	tags        []string
+	timeout     time.Duration
+	concurrency int
+	validation  inputValidation
 }
 
 // NewSimpleFunction creates a function builder for simple input->output transformations
@@ -97,6 +152,14 @@ func NewSimpleFunction[In any, Out any](name, version, description string) *Simp
 // WithHandler sets the simple function handler
 func (f *SimpleFunction[In, Out]) WithHandler(handler func(In) (Out, error)) *SimpleFunction[In, Out] {
 	f.handler = handler
+	f.ctxHandler = nil
+	return f
+}
+
+// WithContextHandler sets a context-aware simple function handler, replacing any handler set with WithHandler
+func (f *SimpleFunction[In, Out]) WithContextHandler(handler func(context.Context, In) (Out, error)) *SimpleFunction[In, Out] {
+	f.ctxHandler = handler
+	f.handler = nil
 	return f
 }
 
@@ -106,16 +169,63 @@ func (f *SimpleFunction[In, Out]) WithTags(tags ...string) *SimpleFunction[In, O
 	return f
 }
 
+// WithTimeout limits how long a single execution may run. A value of 0 disables the limit.
+func (f *SimpleFunction[In, Out]) WithTimeout(timeout time.Duration) *SimpleFunction[In, Out] {
+	f.timeout = timeout
+	return f
+}
+
+// WithMaxConcurrency limits how many executions of this function run at once. A value of 0 disables the limit.
+func (f *SimpleFunction[In, Out]) WithMaxConcurrency(n int) *SimpleFunction[In, Out] {
+	f.concurrency = n
+	return f
+}
+
+// AllowUnknownFields accepts inputs containing fields that are not part of the input type
+func (f *SimpleFunction[In, Out]) AllowUnknownFields() *SimpleFunction[In, Out] {
+	f.validation.allowUnknownFields = true
+	return f
+}
+
+// WithoutInputValidation passes inputs to the handler without checking them against the input schema
+func (f *SimpleFunction[In, Out]) WithoutInputValidation() *SimpleFunction[In, Out] {
+	f.validation.disabled = true
+	return f
+}
+
 // Build creates the actual function implementation
 func (f *SimpleFunction[In, Out]) Build(gs *state.GlobalState) basefunction.FunctionInterface {
-	return basefunction.NewFunction(
+	bf := basefunction.NewFunctionWithContext(
 		f.name,
 		f.version,
 		f.description,
-		func(inputs In, eventState *types.EventMessage) (Out, error) {
+		func(ctx context.Context, inputs In, eventState *types.EventMessage) (Out, error) {
 			// Simple handler ignores event state and global state
+			if f.ctxHandler != nil {
+				return f.ctxHandler(ctx, inputs)
+			}
 			return f.handler(inputs)
 		},
 		f.tags,
 	)
+	bf.SetTimeout(f.timeout)
+	bf.SetMaxConcurrency(f.concurrency)
+	f.validation.apply(bf)
+	return bf
+}
+
+// inputValidation holds the input validation options shared by the function builders
+type inputValidation struct {
+	allowUnknownFields bool
+	disabled           bool
+}
+
+func (v inputValidation) apply(bf interface {
+	SetSchemaOptions(basefunction.SchemaOptions)
+	SetInputValidation(bool)
+}) {
+	if v.allowUnknownFields {
+		bf.SetSchemaOptions(basefunction.SchemaOptions{AllowAdditionalProperties: true})
+	}
+	bf.SetInputValidation(!v.disabled)
 }
@@ -0,0 +1,382 @@
+package basefunction
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONSchemaDialect identifies the JSON Schema draft used by generated schemas
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is the subset of JSON Schema (draft 2020-12) produced from Go types.
+// A schema may also be a boolean schema (true accepts anything, false nothing).
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	MinProperties        *int                   `json:"minProperties,omitempty"`
+	MaxProperties        *int                   `json:"maxProperties,omitempty"`
+	Defs                 map[string]*JSONSchema `json:"$defs,omitempty"`
+
+	// boolean is set for boolean schemas, which marshal as true or false
+	boolean *bool
+}
+
+// BoolSchema returns the boolean schema true (accept anything) or false (accept nothing)
+func BoolSchema(value bool) *JSONSchema {
+	return &JSONSchema{boolean: &value}
+}
+
+// IsFalse reports whether s is the boolean schema false
+func (s *JSONSchema) IsFalse() bool {
+	return s != nil && s.boolean != nil && !*s.boolean
+}
+
+// IsTrue reports whether s is the boolean schema true
+func (s *JSONSchema) IsTrue() bool {
+	return s != nil && s.boolean != nil && *s.boolean
+}
+
+// Resolve follows the local $ref pointers ("#" and "#/$defs/<name>") of sub, a
+// subschema of s. Unknown references resolve to nil.
+func (s *JSONSchema) Resolve(sub *JSONSchema) *JSONSchema {
+	for depth := 0; sub != nil && sub.Ref != "" && depth < 32; depth++ {
+		switch {
+		case sub.Ref == "#":
+			sub = s
+		case strings.HasPrefix(sub.Ref, "#/$defs/"):
+			sub = s.Defs[strings.TrimPrefix(sub.Ref, "#/$defs/")]
+		default:
+			return nil
+		}
+	}
+	return sub
+}
+
+// MarshalJSON encodes boolean schemas as plain booleans
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	if s.boolean != nil {
+		return json.Marshal(*s.boolean)
+	}
+	type plain JSONSchema
+	return json.Marshal((*plain)(s))
+}
+
+// UnmarshalJSON decodes both object and boolean schemas
+func (s *JSONSchema) UnmarshalJSON(data []byte) error {
+	var boolean bool
+	if err := json.Unmarshal(data, &boolean); err == nil {
+		*s = JSONSchema{boolean: &boolean}
+		return nil
+	}
+	type plain JSONSchema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// SchemaOptions tunes schema generation
+type SchemaOptions struct {
+	// AllowAdditionalProperties leaves struct objects open to properties that have no
+	// matching field. By default structs are closed with additionalProperties: false.
+	AllowAdditionalProperties bool
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	invalidDefChars   = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// GenerateJSONSchema builds a JSON Schema (draft 2020-12) for t.
+//
+// Struct fields are named after their json tag and are required unless tagged
+// omitempty. Descriptions come from the `description` tag and constraints from the
+// `validate` tag: required, min, max (value for numbers, length for strings, size for
+// arrays and maps) and oneof (space separated enum values). Named struct types are
+// emitted once under $defs and referenced with $ref, which also covers recursive types.
+func GenerateJSONSchema(t reflect.Type, opts SchemaOptions) *JSONSchema {
+	g := &schemaGenerator{
+		opts:  opts,
+		root:  derefType(t),
+		names: map[reflect.Type]string{},
+		defs:  map[string]*JSONSchema{},
+	}
+	schema := g.schemaFor(t, true)
+	schema.Schema = JSONSchemaDialect
+	if len(g.defs) > 0 {
+		schema.Defs = g.defs
+	}
+	return schema
+}
+
+// schemaGenerator carries the state of a single GenerateJSONSchema call
+type schemaGenerator struct {
+	opts  SchemaOptions
+	root  reflect.Type
+	names map[reflect.Type]string // $defs names of named struct types
+	defs  map[string]*JSONSchema
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type, isRoot bool) *JSONSchema {
+	if t == nil {
+		return &JSONSchema{}
+	}
+	t = derefType(t)
+
+	switch {
+	case t == timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &JSONSchema{}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		// Custom JSON encoding: the shape cannot be derived from the Go type
+		return &JSONSchema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &JSONSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		zero := 0.0
+		return &JSONSchema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			// encoding/json encodes []byte as a base64 string
+			return &JSONSchema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &JSONSchema{Type: "array", Items: g.schemaFor(t.Elem(), false)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem(), false)}
+	case reflect.Struct:
+		return g.structSchema(t, isRoot)
+	default:
+		// Interfaces accept any value; channels and functions cannot be encoded
+		return &JSONSchema{}
+	}
+}
+
+// structSchema inlines the root and anonymous structs and references named structs via $defs
+func (g *schemaGenerator) structSchema(t reflect.Type, isRoot bool) *JSONSchema {
+	if t == g.root && !isRoot {
+		return &JSONSchema{Ref: "#"}
+	}
+	if isRoot || t.Name() == "" {
+		return g.objectSchema(t)
+	}
+
+	if name, ok := g.names[t]; ok {
+		return &JSONSchema{Ref: "#/$defs/" + name}
+	}
+	name := g.defName(t)
+	g.names[t] = name
+	// Reserve the name before descending so recursive references resolve
+	g.defs[name] = &JSONSchema{}
+	g.defs[name] = g.objectSchema(t)
+	return &JSONSchema{Ref: "#/$defs/" + name}
+}
+
+// defName picks a unique $defs key for a named type
+func (g *schemaGenerator) defName(t reflect.Type) string {
+	name := invalidDefChars.ReplaceAllString(t.Name(), "_")
+	if _, taken := g.defs[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	qualified := invalidDefChars.ReplaceAllString(pkg, "_") + "." + name
+	candidate := qualified
+	for i := 2; ; i++ {
+		if _, taken := g.defs[candidate]; !taken {
+			return candidate
+		}
+		candidate = qualified + strconv.Itoa(i)
+	}
+}
+
+// objectSchema describes the JSON object encoding of a struct
+func (g *schemaGenerator) objectSchema(t reflect.Type) *JSONSchema {
+	schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+	g.addFields(schema, t)
+	sort.Strings(schema.Required)
+	if !g.opts.AllowAdditionalProperties {
+		schema.AdditionalProperties = BoolSchema(false)
+	}
+	return schema
+}
+
+// addFields adds the fields of t, promoting those of embedded structs like encoding/json does
+func (g *schemaGenerator) addFields(schema *JSONSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, omitEmpty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+
+		fieldType := derefType(field.Type)
+		if field.Anonymous && fieldType.Kind() == reflect.Struct && !hasJSONName(field) {
+			g.addFields(schema, fieldType)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		// schemaFor returns a fresh schema (or $ref) per call, so annotating it
+		// never alters a shared $defs entry
+		property := g.schemaFor(field.Type, false)
+		property.Description = field.Tag.Get("description")
+		rules := parseValidateTag(field.Tag.Get("validate"))
+		applyConstraints(property, fieldType, rules)
+
+		schema.Properties[name] = property
+		if !omitEmpty || rules.required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// jsonFieldName returns the JSON name of a field and whether it is omitempty or skipped
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" || option == "omitzero" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
+
+// hasJSONName reports whether a field has an explicit name in its json tag
+func hasJSONName(field reflect.StructField) bool {
+	return strings.Split(field.Tag.Get("json"), ",")[0] != ""
+}
+
+// derefType strips pointer indirections
+func derefType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// validateRules holds the constraints parsed from a `validate` struct tag
+type validateRules struct {
+	required bool
+	min      *float64
+	max      *float64
+	oneOf    []string
+}
+
+// parseValidateTag parses tags such as `validate:"required,min=1,max=10,oneof=a b c"`.
+// Unknown rules are ignored.
+func parseValidateTag(tag string) validateRules {
+	var rules validateRules
+	for _, rule := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch key {
+		case "required":
+			rules.required = true
+		case "min", "max":
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			if key == "min" {
+				rules.min = &number
+			} else {
+				rules.max = &number
+			}
+		case "oneof":
+			rules.oneOf = strings.Fields(value)
+		}
+	}
+	return rules
+}
+
+// applyConstraints maps validate rules onto the keywords matching the field's kind
+func applyConstraints(schema *JSONSchema, t reflect.Type, rules validateRules) {
+	switch schema.Type {
+	case "integer", "number":
+		if rules.min != nil {
+			schema.Minimum = rules.min
+		}
+		if rules.max != nil {
+			schema.Maximum = rules.max
+		}
+	case "string":
+		schema.MinLength = intBound(rules.min)
+		schema.MaxLength = intBound(rules.max)
+	case "array":
+		schema.MinItems = intBound(rules.min)
+		schema.MaxItems = intBound(rules.max)
+	case "object":
+		if t.Kind() == reflect.Map {
+			schema.MinProperties = intBound(rules.min)
+			schema.MaxProperties = intBound(rules.max)
+		}
+	}
+
+	for _, value := range rules.oneOf {
+		switch schema.Type {
+		case "integer":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				schema.Enum = append(schema.Enum, n)
+			}
+		case "number":
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				schema.Enum = append(schema.Enum, n)
+			}
+		default:
+			schema.Enum = append(schema.Enum, value)
+		}
+	}
+}
+
+// intBound converts a parsed bound to a length/count keyword value
+func intBound(bound *float64) *int {
+	if bound == nil {
+		return nil
+	}
+	n := int(*bound)
+	return &n
+}
@@ -0,0 +1,114 @@
+package basefunction
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type schemaAddress struct {
+	Street string `json:"street"`
+}
+
+type schemaTreeNode struct {
+	Label    string           `json:"label"`
+	Children []schemaTreeNode `json:"children,omitempty"`
+}
+
+type schemaInput struct {
+	Name     string          `json:"name" description:"Who to greet" validate:"min=1,max=64"`
+	Mode     string          `json:"mode,omitempty" validate:"oneof=formal casual"`
+	Count    int             `json:"count" validate:"min=1,max=10"`
+	Ratio    *float64        `json:"ratio,omitempty"`
+	When     time.Time       `json:"when"`
+	Tags     []string        `json:"tags,omitempty" validate:"max=3"`
+	Labels   map[string]int  `json:"labels,omitempty"`
+	Home     *schemaAddress  `json:"home"`
+	Work     schemaAddress   `json:"work,omitempty"`
+	Tree     schemaTreeNode  `json:"tree,omitempty"`
+	Raw      json.RawMessage `json:"raw,omitempty"`
+	Ignored  string          `json:"-"`
+	internal string
+}
+
+func TestGenerateJSONSchema(t *testing.T) {
+	schema := GenerateJSONSchema(reflect.TypeOf(schemaInput{}), SchemaOptions{})
+
+	if schema.Schema != JSONSchemaDialect || schema.Type != "object" {
+		t.Fatalf("unexpected root: %+v", schema)
+	}
+	if !schema.AdditionalProperties.IsFalse() {
+		t.Errorf("expected structs to be closed")
+	}
+	if want := []string{"count", "home", "name", "when"}; !reflect.DeepEqual(schema.Required, want) {
+		t.Errorf("required = %v, want %v", schema.Required, want)
+	}
+	if _, ok := schema.Properties["Ignored"]; ok {
+		t.Errorf("json:\"-\" field must be skipped")
+	}
+	if _, ok := schema.Properties["internal"]; ok {
+		t.Errorf("unexported field must be skipped")
+	}
+
+	name := schema.Properties["name"]
+	if name.Type != "string" || name.Description != "Who to greet" || *name.MinLength != 1 || *name.MaxLength != 64 {
+		t.Errorf("unexpected name schema: %+v", name)
+	}
+	if mode := schema.Properties["mode"]; !reflect.DeepEqual(mode.Enum, []any{"formal", "casual"}) {
+		t.Errorf("unexpected mode enum: %v", mode.Enum)
+	}
+	if count := schema.Properties["count"]; count.Type != "integer" || *count.Minimum != 1 || *count.Maximum != 10 {
+		t.Errorf("unexpected count schema: %+v", count)
+	}
+	if ratio := schema.Properties["ratio"]; ratio.Type != "number" {
+		t.Errorf("pointer should describe its element, got %+v", ratio)
+	}
+	if when := schema.Properties["when"]; when.Type != "string" || when.Format != "date-time" {
+		t.Errorf("unexpected time schema: %+v", when)
+	}
+	if tags := schema.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" || *tags.MaxItems != 3 {
+		t.Errorf("unexpected tags schema: %+v", tags)
+	}
+	if labels := schema.Properties["labels"]; labels.Type != "object" || labels.AdditionalProperties.Type != "integer" {
+		t.Errorf("unexpected labels schema: %+v", labels)
+	}
+	if raw := schema.Properties["raw"]; raw.Type != "" {
+		t.Errorf("raw JSON should accept any value, got %+v", raw)
+	}
+
+	// Named structs are shared through $defs, recursive types reference themselves
+	if home, work := schema.Properties["home"], schema.Properties["work"]; home.Ref != "#/$defs/schemaAddress" || work.Ref != home.Ref {
+		t.Errorf("expected shared $ref, got %q and %q", home.Ref, work.Ref)
+	}
+	if schema.Properties["tree"].Ref != "#/$defs/schemaTreeNode" {
+		t.Errorf("unexpected tree ref: %q", schema.Properties["tree"].Ref)
+	}
+	tree := schema.Defs["schemaTreeNode"]
+	if tree == nil || tree.Properties["children"].Items.Ref != "#/$defs/schemaTreeNode" {
+		t.Fatalf("expected recursive $defs entry, got %+v", tree)
+	}
+
+	// Boolean schemas survive a JSON round trip
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded JSONSchema
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !decoded.AdditionalProperties.IsFalse() {
+		t.Errorf("additionalProperties: false lost in round trip: %s", data)
+	}
+}
+
+func TestGenerateJSONSchemaRecursiveRoot(t *testing.T) {
+	schema := GenerateJSONSchema(reflect.TypeOf(schemaTreeNode{}), SchemaOptions{AllowAdditionalProperties: true})
+	if schema.Properties["children"].Items.Ref != "#" {
+		t.Fatalf("expected root self reference, got %+v", schema.Properties["children"].Items)
+	}
+	if schema.AdditionalProperties != nil {
+		t.Fatalf("expected open object, got %+v", schema.AdditionalProperties)
+	}
+}
@@ -2,6 +2,7 @@ package basefunction
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/hash"
@@ -18,6 +19,7 @@ type FunctionInterface interface {
 	GetVersion() string
 	GetFunctionDefinition() FunctionDefinition
 	Execute(inputs *[]byte, eventState *types.EventMessage) (*[]byte, error)
+	ExecuteContext(ctx context.Context, inputs *[]byte, eventState *types.EventMessage) (*[]byte, error)
 	Serialize() ([]byte, error)
 }
 
@@ -29,12 +31,21 @@ type FunctionDefinition struct {
 	OutputsType string   `json:"outputs_type"`
 	Server      string   `json:"server"`
 	Tags        []string `json:"tags"`
+	// JSON Schema (draft 2020-12) of the inputs and outputs, published next to the legacy flat types
+	InputSchema  json.RawMessage `json:"input_schema,omitempty"`
+	OutputSchema json.RawMessage `json:"output_schema,omitempty"`
+	// JSON Schema of the function_chunk payloads, only set for streaming functions
+	ChunkSchema json.RawMessage `json:"chunk_schema,omitempty"`
 }
 
 type BaseFunctionDefinition struct {
 	FunctionDefinition
-	InputType  reflect.Type
-	OutputType reflect.Type
+	InputType        reflect.Type
+	OutputType       reflect.Type
+	ChunkType        reflect.Type // nil unless the function streams chunks
+	InputJSONSchema  *JSONSchema  `json:"-"`
+	OutputJSONSchema *JSONSchema  `json:"-"`
+	schemaOptions    SchemaOptions
 }
 
 // NewBaseFunctionDefinition creates a new base function with input/output type information
@@ -49,7 +60,7 @@ func NewBaseFunctionDefinition(name, version, description string, inputType, out
 
 	server := os.Getenv("SERVER_NAME")
 
-	return &BaseFunctionDefinition{
+	base := &BaseFunctionDefinition{
 		FunctionDefinition: FunctionDefinition{
 			Name:        name,
 			Version:     version,
@@ -62,8 +73,31 @@ func NewBaseFunctionDefinition(name, version, description string, inputType, out
 		InputType:  inputType,
 		OutputType: outputType,
 	}
+	base.SetSchemaOptions(SchemaOptions{})
+	return base
 }
 
+// SetSchemaOptions regenerates the input, output and chunk JSON Schemas with the given options
+func (b *BaseFunctionDefinition) SetSchemaOptions(opts SchemaOptions) {
+	b.schemaOptions = opts
+	b.InputJSONSchema = GenerateJSONSchema(b.InputType, opts)
+	b.OutputJSONSchema = GenerateJSONSchema(b.OutputType, opts)
+	b.InputSchema, _ = json.Marshal(b.InputJSONSchema)
+	b.OutputSchema, _ = json.Marshal(b.OutputJSONSchema)
+	if b.ChunkType != nil {
+		b.ChunkSchema, _ = json.Marshal(GenerateJSONSchema(b.ChunkType, opts))
+	}
+}
+
+// SetChunkType marks the function as streaming chunks of type t and publishes their schema
+func (b *BaseFunctionDefinition) SetChunkType(t reflect.Type) {
+	b.ChunkType = t
+	b.ChunkSchema, _ = json.Marshal(GenerateJSONSchema(t, b.schemaOptions))
+}
+
+// IsStreaming reports whether the function streams chunks
+func (b *BaseFunctionDefinition) IsStreaming() bool { return b.ChunkType != nil }
+
 // SetServer allows injecting server name after construction
 func (b *BaseFunctionDefinition) SetServer(name string) { b.Server = name }
 
@@ -275,8 +309,15 @@ func processArrayField(arrayType reflect.Type, path string, result map[string]in
 type Function[In any, Out any] struct {
 	BaseFunctionDefinition
 	Handler func(In, *types.EventMessage) (Out, error)
-	Cache   FunctionCache
-	TTL     time.Duration
+	// ContextHandler takes precedence over Handler when set and receives the
+	// execution context, which is cancelled when the workflow cancels the run
+	ContextHandler func(context.Context, In, *types.EventMessage) (Out, error)
+	Cache          FunctionCache
+	TTL            time.Duration
+	Timeout        time.Duration // maximum execution time; 0 means no limit
+	MaxConcurrency int           // maximum concurrent executions; 0 means no limit
+	// SkipInputValidation disables checking inputs against InputJSONSchema before the handler runs
+	SkipInputValidation bool
 }
 
 // FunctionCache abstracts caching for functions (backed by Redis or gRPC cache)
@@ -310,6 +351,19 @@ func NewFunction[In any, Out any](
 	}
 }
 
+// NewFunctionWithContext creates a new function whose handler receives the execution context
+func NewFunctionWithContext[In any, Out any](
+	name string,
+	version string,
+	description string,
+	handler func(context.Context, In, *types.EventMessage) (Out, error),
+	tags []string,
+) *Function[In, Out] {
+	f := NewFunction[In, Out](name, version, description, nil, tags)
+	f.ContextHandler = handler
+	return f
+}
+
 // SetCache sets the cache for the function
 func (f *Function[In, Out]) SetCache(cache FunctionCache) {
 	f.Cache = cache
@@ -320,8 +374,52 @@ func (f *Function[In, Out]) SetCacheTTL(ttl time.Duration) {
 	f.TTL = ttl
 }
 
+// SetTimeout sets the maximum execution time for this function. A value of 0 disables the limit.
+func (f *Function[In, Out]) SetTimeout(timeout time.Duration) {
+	f.Timeout = timeout
+}
+
+// GetTimeout returns the maximum execution time for this function
+func (f *Function[In, Out]) GetTimeout() time.Duration {
+	return f.Timeout
+}
+
+// SetMaxConcurrency limits how many executions of this function run at once. A value of 0 disables the limit.
+func (f *Function[In, Out]) SetMaxConcurrency(n int) {
+	f.MaxConcurrency = n
+}
+
+// GetMaxConcurrency returns the maximum number of concurrent executions for this function
+func (f *Function[In, Out]) GetMaxConcurrency() int {
+	return f.MaxConcurrency
+}
+
+// SetInputValidation enables or disables validating inputs against the input schema
+func (f *Function[In, Out]) SetInputValidation(enabled bool) {
+	f.SkipInputValidation = !enabled
+}
+
 // Execute implements the FunctionInterface
 func (f *Function[In, Out]) Execute(inputs *[]byte, eventState *types.EventMessage) (*[]byte, error) {
+	return f.ExecuteContext(context.Background(), inputs, eventState)
+}
+
+// ExecuteContext implements the FunctionInterface, passing ctx on to context-aware handlers
+func (f *Function[In, Out]) ExecuteContext(ctx context.Context, inputs *[]byte, eventState *types.EventMessage) (*[]byte, error) {
+	// A request without payload, or with an explicit null, means no arguments: an empty
+	// object for object inputs, so parameterless functions and optional fields still work
+	if inputs == nil || len(bytes.TrimSpace(*inputs)) == 0 || string(bytes.TrimSpace(*inputs)) == "null" {
+		empty := []byte("null")
+		if f.InputJSONSchema != nil && f.InputJSONSchema.Type == "object" {
+			empty = []byte("{}")
+		}
+		inputs = &empty
+	}
+
+	if err := f.validateInputs(*inputs); err != nil {
+		return nil, err
+	}
+
 	// If cache is available and TTL != 0, try to get the cached result
 	if f.Cache != nil && f.TTL != 0 {
 		cacheKey := hash.Generate(*inputs, f.Name, f.Version)
@@ -355,10 +453,14 @@ func (f *Function[In, Out]) Execute(inputs *[]byte, eventState *types.EventMessa
 
 	var input In
 	if err := json.Unmarshal(*inputs, &input); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal input: %w", err)
+		return nil, types.Errorf(types.ErrorCodeInvalidInput, "failed to unmarshal input: %v", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("execution aborted before handler started: %w", context.Cause(ctx))
 	}
 
-	result, err := f.Handler(input, eventState)
+	result, err := f.callHandler(ctx, input, eventState)
 	if err != nil {
 		return nil, fmt.Errorf("handler error: %w", err)
 	}
@@ -384,3 +486,35 @@ func (f *Function[In, Out]) Execute(inputs *[]byte, eventState *types.EventMessa
 
 	return &output, nil
 }
+
+// validateInputs checks the raw payload against the input schema and reports every
+// violation in a single invalid_input error
+func (f *Function[In, Out]) validateInputs(inputs []byte) error {
+	if f.SkipInputValidation || f.InputJSONSchema == nil {
+		return nil
+	}
+	violations := ValidateJSON(f.InputJSONSchema, inputs)
+	if len(violations) == 0 {
+		return nil
+	}
+	messages := make([]string, len(violations))
+	for i, violation := range violations {
+		messages[i] = violation.String()
+	}
+	return types.Errorf(types.ErrorCodeInvalidInput, "invalid input: %s", strings.Join(messages, "; ")).
+		WithDetails(map[string]any{"errors": violations})
+}
+
+// callHandler invokes the context-aware handler when present, falling back to Handler.
+// A panic in the handler is recovered and returned as a *PanicError.
+func (f *Function[In, Out]) callHandler(ctx context.Context, input In, eventState *types.EventMessage) (result Out, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewPanicError(r)
+		}
+	}()
+	if f.ContextHandler != nil {
+		return f.ContextHandler(ctx, input, eventState)
+	}
+	return f.Handler(input, eventState)
+}
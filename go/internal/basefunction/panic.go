@@ -0,0 +1,62 @@
+package basefunction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// maxDigestFrames bounds how many stack frames are kept in a panic digest
+const maxDigestFrames = 8
+
+// PanicError is returned by Execute when the handler panics
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+// NewPanicError captures the current goroutine's stack for a recovered panic value
+func NewPanicError(value any) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Digest returns a short, stable hash of the panicking call path (without arguments
+// or goroutine IDs) so that repeated crashes at the same place group together
+func (e *PanicError) Digest() string {
+	sum := sha256.Sum256([]byte(strings.Join(e.Frames(), "\n")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Frames returns the names of the innermost functions on the stack, starting
+// at the function that panicked
+func (e *PanicError) Frames() []string {
+	var names []string
+	for _, line := range strings.Split(string(e.Stack), "\n") {
+		// Function lines are unindented; file:line entries start with a tab
+		if line == "" || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		if i := strings.LastIndex(line, "("); i > 0 {
+			line = line[:i]
+		}
+		names = append(names, line)
+	}
+
+	// Drop the recovery machinery: everything up to and including the runtime's panic frame
+	for i := range names {
+		if names[i] == "panic" {
+			names = names[i+1:]
+			break
+		}
+	}
+	if len(names) > maxDigestFrames {
+		names = names[:maxDigestFrames]
+	}
+	return names
+}
@@ -0,0 +1,45 @@
+package basefunction
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+type panicInput struct {
+	Value string `json:"value"`
+}
+
+func panickingHandler(in panicInput, _ *types.EventMessage) (panicInput, error) {
+	panic("boom: " + in.Value)
+}
+
+func TestExecuteRecoversHandlerPanic(t *testing.T) {
+	fn := NewFunction[panicInput, panicInput]("panics", "1.0.0", "", panickingHandler, nil)
+
+	input := []byte(`{"value":"x"}`)
+	_, err := fn.Execute(&input, &types.EventMessage{})
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected a *PanicError, got %v", err)
+	}
+	if panicErr.Value != "boom: x" {
+		t.Fatalf("unexpected panic value: %v", panicErr.Value)
+	}
+
+	frames := panicErr.Frames()
+	if len(frames) == 0 || !strings.HasSuffix(frames[0], "panickingHandler") {
+		t.Fatalf("expected the first frame to be the panicking handler, got %v", frames)
+	}
+
+	// The digest only depends on the call path, not on the panic value
+	input = []byte(`{"value":"y"}`)
+	_, err = fn.Execute(&input, &types.EventMessage{})
+	var second *PanicError
+	if !errors.As(err, &second) || second.Digest() != panicErr.Digest() {
+		t.Fatalf("expected identical digests, got %v and %v", panicErr.Digest(), second)
+	}
+}
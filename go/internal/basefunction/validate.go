@@ -0,0 +1,305 @@
+package basefunction
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes a single schema violation at a field path such as "items[2].name"
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// ValidateJSON checks data against schema and returns every violation found.
+// Null is accepted for any property or array item, mirroring how encoding/json
+// leaves such fields at their zero value; required only demands the key is present.
+func ValidateJSON(schema *JSONSchema, data []byte) []FieldError {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []FieldError{{Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	v := &validator{root: schema}
+	v.validate(schema, value, "")
+	return v.errors
+}
+
+// validator accumulates violations while walking a document
+type validator struct {
+	root   *JSONSchema
+	errors []FieldError
+}
+
+func (v *validator) fail(path, format string, args ...any) {
+	v.errors = append(v.errors, FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(schema *JSONSchema, value any, path string) {
+	schema = v.root.Resolve(schema)
+	if schema == nil || schema.IsTrue() {
+		return
+	}
+	if schema.IsFalse() {
+		v.fail(path, "is not allowed")
+		return
+	}
+
+	if schema.Type != "" && !matchesType(schema.Type, value) {
+		v.fail(path, "must be %s, got %s", withArticle(schema.Type), jsonTypeName(value))
+		return
+	}
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		v.fail(path, "must be one of %s", formatEnum(schema.Enum))
+	}
+
+	switch typed := value.(type) {
+	case json.Number:
+		v.validateNumber(schema, typed, path)
+	case string:
+		v.validateString(schema, typed, path)
+	case []any:
+		v.validateArray(schema, typed, path)
+	case map[string]any:
+		v.validateObject(schema, typed, path)
+	}
+}
+
+func (v *validator) validateNumber(schema *JSONSchema, number json.Number, path string) {
+	n, err := number.Float64()
+	if err != nil {
+		v.fail(path, "is not a valid number")
+		return
+	}
+	if schema.Minimum != nil && n < *schema.Minimum {
+		v.fail(path, "must be >= %s", formatNumber(*schema.Minimum))
+	}
+	if schema.Maximum != nil && n > *schema.Maximum {
+		v.fail(path, "must be <= %s", formatNumber(*schema.Maximum))
+	}
+}
+
+func (v *validator) validateString(schema *JSONSchema, s string, path string) {
+	length := utf8.RuneCountInString(s)
+	if schema.MinLength != nil && length < *schema.MinLength {
+		v.fail(path, "must be at least %d characters long", *schema.MinLength)
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		v.fail(path, "must be at most %d characters long", *schema.MaxLength)
+	}
+	if schema.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+			v.fail(path, "must be an RFC 3339 date-time")
+		}
+	}
+	if schema.ContentEncoding == "base64" {
+		if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+			v.fail(path, "must be base64 encoded")
+		}
+	}
+}
+
+func (v *validator) validateArray(schema *JSONSchema, items []any, path string) {
+	if schema.MinItems != nil && len(items) < *schema.MinItems {
+		v.fail(path, "must contain at least %d items", *schema.MinItems)
+	}
+	if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+		v.fail(path, "must contain at most %d items", *schema.MaxItems)
+	}
+	if schema.Items == nil {
+		return
+	}
+	for i, item := range items {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		if item == nil {
+			continue
+		}
+		v.validate(schema.Items, item, itemPath)
+	}
+}
+
+func (v *validator) validateObject(schema *JSONSchema, object map[string]any, path string) {
+	if schema.MinProperties != nil && len(object) < *schema.MinProperties {
+		v.fail(path, "must contain at least %d entries", *schema.MinProperties)
+	}
+	if schema.MaxProperties != nil && len(object) > *schema.MaxProperties {
+		v.fail(path, "must contain at most %d entries", *schema.MaxProperties)
+	}
+
+	// Keys name properties case-insensitively, like encoding/json matches struct fields
+	matched := make(map[string]bool, len(object))
+	for key := range object {
+		if name, ok := propertyName(schema.Properties, key); ok {
+			matched[name] = true
+		}
+	}
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok && !matched[name] {
+			v.fail(joinPath(path, name), "is required")
+		}
+	}
+
+	// Visit keys in order so errors are reported deterministically
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := object[key]
+		keyPath := joinPath(path, key)
+		name, known := propertyName(schema.Properties, key)
+		property := schema.Properties[name]
+		if !known {
+			if schema.AdditionalProperties.IsFalse() {
+				v.fail(keyPath, "unknown field")
+				continue
+			}
+			property = schema.AdditionalProperties
+		}
+		if property == nil || value == nil {
+			continue
+		}
+		v.validate(property, value, keyPath)
+	}
+}
+
+// propertyName returns the property a key sets: the one named exactly like it, otherwise
+// one whose name only differs in case
+func propertyName(properties map[string]*JSONSchema, key string) (string, bool) {
+	if _, ok := properties[key]; ok {
+		return key, true
+	}
+	match, found := "", false
+	for name := range properties {
+		if strings.EqualFold(name, key) && (!found || name < match) {
+			match, found = name, true
+		}
+	}
+	return match, found
+}
+
+// matchesType reports whether a decoded JSON value has the given JSON Schema type
+func matchesType(schemaType string, value any) bool {
+	switch schemaType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		if _, err := number.Int64(); err == nil {
+			return true
+		}
+		f, err := number.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+// jsonTypeName names the JSON type of a decoded value for error messages
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// inEnum compares strings exactly and numbers by value
+func inEnum(enum []any, value any) bool {
+	for _, candidate := range enum {
+		switch typed := value.(type) {
+		case json.Number:
+			n, err := typed.Float64()
+			if err != nil {
+				continue
+			}
+			switch c := candidate.(type) {
+			case int64:
+				if float64(c) == n {
+					return true
+				}
+			case float64:
+				if c == n {
+					return true
+				}
+			}
+		default:
+			if candidate == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []any) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = fmt.Sprintf("%v", value)
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func withArticle(schemaType string) string {
+	switch schemaType {
+	case "array", "integer", "object":
+		return "an " + schemaType
+	}
+	return "a " + schemaType
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,117 @@
+package basefunction
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+func TestValidateJSON(t *testing.T) {
+	schema := GenerateJSONSchema(reflect.TypeOf(schemaInput{}), SchemaOptions{})
+
+	valid := `{"name":"Ada","count":3,"when":"2024-01-02T03:04:05Z","home":null,"mode":"casual","tags":["a"],
+		"tree":{"label":"root","children":[{"label":"leaf"}]}}`
+	if violations := ValidateJSON(schema, []byte(valid)); len(violations) != 0 {
+		t.Fatalf("expected valid input, got %v", violations)
+	}
+
+	invalid := `{"name":"","count":11.5,"mode":"rude","tags":["a","b","c","d"],"extra":true,
+		"tree":{"label":"root","children":[{"label":1}]},"work":{"street":"x","zip":"1"}}`
+	got := ValidateJSON(schema, []byte(invalid))
+	want := []FieldError{
+		{Field: "home", Message: "is required"},
+		{Field: "when", Message: "is required"},
+		{Field: "count", Message: "must be an integer, got number"},
+		{Field: "extra", Message: "unknown field"},
+		{Field: "mode", Message: "must be one of [formal, casual]"},
+		{Field: "name", Message: "must be at least 1 characters long"},
+		{Field: "tags", Message: "must contain at most 3 items"},
+		{Field: "tree.children[0].label", Message: "must be a string, got number"},
+		{Field: "work.zip", Message: "unknown field"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("violations mismatch\n got: %v\nwant: %v", got, want)
+	}
+
+	// Keys match fields regardless of case, as encoding/json decodes them
+	relaxed := `{"NAME":"Ada","Count":3,"When":"2024-01-02T03:04:05Z","home":null,"Mode":"casual","Tags":["a"],
+		"tree":{"Label":"root"}}`
+	if violations := ValidateJSON(schema, []byte(relaxed)); len(violations) != 0 {
+		t.Fatalf("expected keys to match regardless of case, got %v", violations)
+	}
+	if violations := ValidateJSON(schema, []byte(strings.Replace(relaxed, `"Count":3`, `"Count":"3"`, 1))); len(violations) != 1 || violations[0].Field != "Count" {
+		t.Fatalf("expected the case-insensitive match to be validated, got %v", violations)
+	}
+
+	// An exact match wins over one that only differs in case
+	cased := &JSONSchema{
+		Type:       "object",
+		Properties: map[string]*JSONSchema{"id": {Type: "string"}, "ID": {Type: "integer"}},
+		Required:   []string{"id"},
+	}
+	if violations := ValidateJSON(cased, []byte(`{"id":"a","ID":1}`)); len(violations) != 0 {
+		t.Fatalf("expected exact matches, got %v", violations)
+	}
+	if got := ValidateJSON(cased, []byte(`{"ID":1}`)); len(got) != 1 || got[0].Field != "id" || got[0].Message != "is required" {
+		t.Fatalf("expected id to be missing, got %v", got)
+	}
+
+	open := GenerateJSONSchema(reflect.TypeOf(schemaAddress{}), SchemaOptions{AllowAdditionalProperties: true})
+	if violations := ValidateJSON(open, []byte(`{"street":"x","zip":"1"}`)); len(violations) != 0 {
+		t.Fatalf("expected unknown fields to be allowed, got %v", violations)
+	}
+}
+
+func TestExecuteRejectsInvalidInput(t *testing.T) {
+	called := false
+	fn := NewFunction[schemaAddress, schemaAddress]("echo", "1.0.0", "", func(in schemaAddress, _ *types.EventMessage) (schemaAddress, error) {
+		called = true
+		return in, nil
+	}, nil)
+
+	input := []byte(`{"street":1,"zip":"1"}`)
+	_, err := fn.Execute(&input, &types.EventMessage{})
+	var sdkErr *types.Error
+	if !errors.As(err, &sdkErr) || sdkErr.Code != types.ErrorCodeInvalidInput {
+		t.Fatalf("expected invalid_input error, got %v", err)
+	}
+	if called {
+		t.Fatalf("handler must not run on invalid input")
+	}
+	if want := "invalid input: street: must be a string, got number; zip: unknown field"; sdkErr.Message != want {
+		t.Fatalf("message = %q, want %q", sdkErr.Message, want)
+	}
+
+	fn.SetInputValidation(false)
+	input = []byte(`{"street":"x","zip":"1"}`)
+	if _, err := fn.Execute(&input, &types.EventMessage{}); err != nil || !called {
+		t.Fatalf("expected handler to run with validation disabled, got %v", err)
+	}
+}
+
+func TestExecuteTreatsMissingPayloadAsEmptyObject(t *testing.T) {
+	type optionalInput struct {
+		Name string `json:"name,omitempty"`
+	}
+	fn := NewFunction[optionalInput, string]("greet", "1.0.0", "", func(in optionalInput, _ *types.EventMessage) (string, error) {
+		return "hello " + in.Name, nil
+	}, nil)
+	null := []byte("null")
+	for _, inputs := range []*[]byte{nil, &null} {
+		output, err := fn.Execute(inputs, &types.EventMessage{})
+		if err != nil || string(*output) != `"hello "` {
+			t.Fatalf("expected the handler to run with a zero input, got %v", err)
+		}
+	}
+
+	// Required fields are still reported
+	strict := NewFunction[schemaAddress, schemaAddress]("echo", "1.0.0", "", func(in schemaAddress, _ *types.EventMessage) (schemaAddress, error) {
+		return in, nil
+	}, nil)
+	if _, err := strict.Execute(nil, &types.EventMessage{}); types.ErrorCodeOf(err) != types.ErrorCodeInvalidInput {
+		t.Fatalf("expected invalid_input for missing required fields, got %v", err)
+	}
+}
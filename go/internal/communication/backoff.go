@@ -0,0 +1,46 @@
+package communication
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff configures the delay between reconnection attempts. The delay grows
+// exponentially and is drawn uniformly from [0, cap] ("full jitter"), so a fleet of
+// workers losing the server at the same moment does not reconnect in lockstep.
+type Backoff struct {
+	// Initial is the upper bound of the first delay
+	Initial time.Duration
+	// Max caps the upper bound as it grows
+	Max time.Duration
+	// Multiplier grows the upper bound after every failed attempt
+	Multiplier float64
+}
+
+// DefaultBackoff starts at 5 seconds and doubles up to one minute
+var DefaultBackoff = Backoff{Initial: 5 * time.Second, Max: time.Minute, Multiplier: 2}
+
+// withDefaults fills unset fields from DefaultBackoff
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoff.Initial
+	}
+	if b.Max < b.Initial {
+		b.Max = max(DefaultBackoff.Max, b.Initial)
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	}
+	return b
+}
+
+// Delay returns a random delay before the given retry, counting from 0
+func (b Backoff) Delay(attempt int) time.Duration {
+	b = b.withDefaults()
+	ceiling := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if ceiling > float64(b.Max) {
+		ceiling = float64(b.Max)
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
@@ -15,5 +15,12 @@ type WorkflowCommunicator interface {
 
 	// IsConnected returns whether the communicator is currently connected
 	IsConnected() bool
+
+	// State returns the current connection state
+	State() ConnectionState
+
+	// SubscribeState returns a channel receiving the current state and every change,
+	// and a function that ends the subscription. The channel is closed after StateClosed.
+	SubscribeState() (<-chan ConnectionState, func())
 }
 
@@ -0,0 +1,88 @@
+package communication
+
+import "sync"
+
+// ConnectionState describes the connection to the workflow server
+type ConnectionState string
+
+const (
+	// StateConnecting is the state before the first connection is established
+	StateConnecting ConnectionState = "connecting"
+	// StateConnected means the event stream is open and the worker is registered
+	StateConnected ConnectionState = "connected"
+	// StateReconnecting means the connection was lost and is being re-established
+	StateReconnecting ConnectionState = "reconnecting"
+	// StateAuthFailed means the server rejected the API token; connection attempts continue
+	StateAuthFailed ConnectionState = "auth_failed"
+	// StateClosed is final, the communicator was closed
+	StateClosed ConnectionState = "closed"
+)
+
+// stateNotifier holds the current connection state and publishes changes to subscribers
+type stateNotifier struct {
+	mu          sync.Mutex
+	state       ConnectionState
+	subscribers map[chan ConnectionState]struct{}
+}
+
+func newStateNotifier(initial ConnectionState) *stateNotifier {
+	return &stateNotifier{state: initial, subscribers: make(map[chan ConnectionState]struct{})}
+}
+
+// current returns the current state
+func (n *stateNotifier) current() ConnectionState {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state
+}
+
+// subscribe returns a channel that receives the current state and every later change.
+// A slow subscriber only misses intermediate states, it always sees the latest one.
+// The channel is closed after StateClosed or when cancel is called.
+func (n *stateNotifier) subscribe() (<-chan ConnectionState, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ch := make(chan ConnectionState, 1)
+	ch <- n.state
+	if n.state == StateClosed {
+		close(ch)
+		return ch, func() {}
+	}
+	n.subscribers[ch] = struct{}{}
+
+	cancel := func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if _, ok := n.subscribers[ch]; ok {
+			delete(n.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// set changes the state and notifies subscribers. Once closed the state never changes.
+func (n *stateNotifier) set(state ConnectionState) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.state == state || n.state == StateClosed {
+		return
+	}
+	n.state = state
+	for ch := range n.subscribers {
+		// Replace an unread state with the newer one
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- state
+		if state == StateClosed {
+			close(ch)
+		}
+	}
+	if state == StateClosed {
+		clear(n.subscribers)
+	}
+}
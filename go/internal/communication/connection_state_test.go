@@ -0,0 +1,67 @@
+package communication
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/workflowsgrpc"
+	"google.golang.org/grpc"
+)
+
+func TestBackoffDelayStaysWithinCappedBound(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	for attempt := 0; attempt < 20; attempt++ {
+		bound := b.Initial
+		for i := 0; i < attempt && bound < b.Max; i++ {
+			bound *= 2
+		}
+		bound = min(bound, b.Max)
+		for i := 0; i < 50; i++ {
+			if delay := b.Delay(attempt); delay < 0 || delay > bound {
+				t.Fatalf("attempt %d: delay %v outside [0, %v]", attempt, delay, bound)
+			}
+		}
+	}
+}
+
+func TestGrpcCommunicatorPublishesStateChanges(t *testing.T) {
+	grpcServer := grpc.NewServer()
+	workflowsgrpc.RegisterEventServiceServer(grpcServer, &registrationServer{registered: make(chan string, 1)})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	gc := NewGrpcCommunicatorWithOptions(listener.Addr().String(), "state-worker", "", 10, 1, 30)
+	states, cancel := gc.SubscribeState()
+	defer cancel()
+
+	expect := func(want ConnectionState) {
+		t.Helper()
+		select {
+		case got := <-states:
+			if got != want {
+				t.Fatalf("expected state %q, got %q", want, got)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no state change to %q", want)
+		}
+	}
+
+	expect(StateConnecting)
+	gc.Connect()
+	expect(StateConnected)
+
+	// Losing the server makes the worker reconnect
+	grpcServer.Stop()
+	expect(StateReconnecting)
+
+	gc.Close()
+	expect(StateClosed)
+	if _, open := <-states; open {
+		t.Fatal("subscription must be closed after StateClosed")
+	}
+}
@@ -9,6 +9,9 @@ var (
 	// ErrChannelFull indicates the communication channel is full
 	ErrChannelFull = errors.New("communication channel is full")
 
+	// ErrQueueFull indicates the outbound queue cannot hold more events while disconnected
+	ErrQueueFull = errors.New("outbound queue is full")
+
 	// ErrInvalidMode indicates an invalid communication mode was specified
 	ErrInvalidMode = errors.New("invalid communication mode")
 )
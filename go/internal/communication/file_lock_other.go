@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package communication
+
+import "os"
+
+// lockFile opens path. Files cannot be locked on this platform, so workers sharing a
+// server name must use different queue directories.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+}
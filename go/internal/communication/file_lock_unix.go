@@ -0,0 +1,27 @@
+//go:build unix
+
+package communication
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile opens path and locks it exclusively without waiting, failing with errLocked
+// when another file holds the lock. The lock ends when the file is closed or the
+// process exits.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
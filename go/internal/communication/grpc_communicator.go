@@ -2,12 +2,14 @@ package communication
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/workflowsgrpc"
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
@@ -25,7 +27,23 @@ type GrpcCommunicator struct {
 	client        workflowsgrpc.EventServiceClient
 	stream        grpc.BidiStreamingClient[workflowsgrpc.GrpcEventMessage, workflowsgrpc.GrpcEventMessage]
 	serverName    string
-	apiToken      string
+	tokens        TokenSource  // nil sends no authorization metadata
+	tls           *tlsReloader // nil dials without transport security
+	outbound      *outboundQueue
+	// registrationMeta supplies the meta of client_registration events
+	registrationMeta atomic.Pointer[func() map[string]any]
+	// responseHandler is offered received events before they are queued
+	responseHandler atomic.Pointer[func(*types.EventMessage) bool]
+	// dropped counts events discarded instead of sent or delivered
+	dropped atomic.Uint64
+
+	// refreshTimer re-establishes the stream before the token it was opened with expires
+	refreshTimer *time.Timer
+	// streamExpiry is the expiry of the token the current stream was opened with
+	streamExpiry time.Time
+	// authFailures counts consecutive Unauthenticated errors; only the first one is
+	// retried immediately
+	authFailures atomic.Int32
 
 	// Channel for incoming events
 	incomingEvents chan *types.EventMessage
@@ -37,10 +55,18 @@ type GrpcCommunicator struct {
 	// Synchronization
 	mu          sync.RWMutex
 	connected   bool
+	sendMu      sync.Mutex // serializes sends on the current stream
 	reconnectCh chan struct{}
+	states      *stateNotifier
 
-	// Configurable intervals (seconds). If 0, defaults are used by caller.
-	reconnectIntervalSec   int
+	// receivers tracks receiveMessages goroutines so Close can wait for them
+	// before closing incomingEvents
+	receivers sync.WaitGroup
+	closeOnce sync.Once
+
+	// backoff spaces out reconnection attempts
+	backoff Backoff
+	// Configurable interval (seconds). If 0, defaults are used by caller.
 	healthcheckIntervalSec int
 }
 
@@ -51,12 +77,14 @@ func NewGrpcCommunicator(serverAddress, serverName, apiToken string) *GrpcCommun
 	return &GrpcCommunicator{
 		serverAddress:          serverAddress,
 		serverName:             serverName,
-		apiToken:               apiToken,
+		tokens:                 tokenSourceFor(apiToken),
 		incomingEvents:         make(chan *types.EventMessage, 100), // Buffered channel
 		ctx:                    ctx,
 		cancel:                 cancel,
 		reconnectCh:            make(chan struct{}, 1),
-		reconnectIntervalSec:   5,
+		states:                 newStateNotifier(StateConnecting),
+		outbound:               &outboundQueue{config: OutboundQueueConfig{Size: DefaultOutboundQueueSize}},
+		backoff:                DefaultBackoff,
 		healthcheckIntervalSec: 30,
 	}
 }
@@ -76,28 +104,109 @@ func NewGrpcCommunicatorWithOptions(serverAddress, serverName, apiToken string,
 	return &GrpcCommunicator{
 		serverAddress:          serverAddress,
 		serverName:             serverName,
-		apiToken:               apiToken,
+		tokens:                 tokenSourceFor(apiToken),
 		incomingEvents:         make(chan *types.EventMessage, incomingBuffer),
 		ctx:                    ctx,
 		cancel:                 cancel,
 		reconnectCh:            make(chan struct{}, 1),
-		reconnectIntervalSec:   reconnectIntervalSec,
+		states:                 newStateNotifier(StateConnecting),
+		outbound:               &outboundQueue{config: OutboundQueueConfig{Size: DefaultOutboundQueueSize}},
+		backoff:                Backoff{Initial: time.Duration(reconnectIntervalSec) * time.Second}.withDefaults(),
 		healthcheckIntervalSec: healthcheckIntervalSec,
 	}
 }
 
+// tokenSourceFor wraps a static API token, an empty token means no authentication
+func tokenSourceFor(apiToken string) TokenSource {
+	if apiToken == "" {
+		return nil
+	}
+	return NewStaticTokenSource(apiToken)
+}
+
+// SetTokenSource replaces the source of the API token for subsequent streams
+func (gc *GrpcCommunicator) SetTokenSource(source TokenSource) {
+	gc.mu.Lock()
+	gc.tokens = source
+	gc.mu.Unlock()
+}
+
+// SetBackoff configures the delay between reconnection attempts; zero fields use DefaultBackoff
+func (gc *GrpcCommunicator) SetBackoff(backoff Backoff) {
+	gc.mu.Lock()
+	gc.backoff = backoff.withDefaults()
+	gc.mu.Unlock()
+}
+
+// SetOutboundQueue configures how events sent while disconnected are handled.
+// A disk-backed queue loads the events a previous run could not send.
+func (gc *GrpcCommunicator) SetOutboundQueue(config OutboundQueueConfig) error {
+	queue, err := newOutboundQueue(config, gc.serverName)
+	if err != nil {
+		return err
+	}
+	gc.mu.Lock()
+	previous := gc.outbound
+	gc.outbound = queue
+	gc.mu.Unlock()
+	previous.close()
+	return nil
+}
+
+// SetRegistrationMeta sets a function providing the meta sent with every
+// client_registration, e.g. the worker's capacity
+func (gc *GrpcCommunicator) SetRegistrationMeta(meta func() map[string]any) {
+	gc.registrationMeta.Store(&meta)
+}
+
+// SetResponseHandler installs a function offered every received event before it is
+// queued on ReceiveEvents. Events it returns true for are consumed, which lets
+// correlated responses reach their waiting callers without queueing behind other events.
+func (gc *GrpcCommunicator) SetResponseHandler(handler func(*types.EventMessage) bool) {
+	gc.responseHandler.Store(&handler)
+}
+
+// SetTLSConfig enables transport security for subsequent connection attempts.
+// The certificate files are loaded once to report configuration errors early.
+func (gc *GrpcCommunicator) SetTLSConfig(config TLSConfig) error {
+	var reloader *tlsReloader
+	if config.Enabled {
+		var err error
+		if reloader, err = newTLSReloader(config); err != nil {
+			return err
+		}
+	}
+	gc.mu.Lock()
+	gc.tls = reloader
+	gc.mu.Unlock()
+	return nil
+}
+
 // Connect starts the connection process and retries until successful
 func (gc *GrpcCommunicator) Connect() error {
 	// Start connection attempts in background
-	go gc.connectionLoop()
+	go gc.connectionMonitor()
+	go gc.connectionLoop(true)
 
-	log.Printf("Started gRPC connection attempts to %s (will retry every 5 seconds until successful)", gc.serverAddress)
+	log.Printf("Started gRPC connection attempts to %s (retrying with backoff up to %v until successful)", gc.serverAddress, gc.reconnectBackoff().Max)
 	return nil
 }
 
-// connectionLoop continuously attempts to connect with 5-second intervals
-func (gc *GrpcCommunicator) connectionLoop() {
-	for {
+// connectionLoop attempts to connect until it succeeds, waiting a jittered, growing
+// delay between attempts. Unless immediate is set it also waits before the first
+// attempt, so workers that lost the server together do not all return at once.
+func (gc *GrpcCommunicator) connectionLoop(immediate bool) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 || !immediate {
+			delay := gc.reconnectBackoff().Delay(attempt)
+			log.Printf("Next connection attempt to %s in %v", gc.serverAddress, delay.Round(time.Millisecond))
+			select {
+			case <-gc.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
 		select {
 		case <-gc.ctx.Done():
 			return
@@ -105,45 +214,83 @@ func (gc *GrpcCommunicator) connectionLoop() {
 		}
 
 		if gc.attemptConnection() {
-			// Successfully connected, start monitoring
-			go gc.connectionMonitor()
-			return
-		}
-
-		// Wait before next attempt
-		select {
-		case <-gc.ctx.Done():
 			return
-		case <-time.After(time.Duration(gc.reconnectIntervalSec) * time.Second):
-			continue
 		}
 	}
 }
 
+// reconnectBackoff returns the backoff configuration
+func (gc *GrpcCommunicator) reconnectBackoff() Backoff {
+	gc.mu.RLock()
+	defer gc.mu.RUnlock()
+	return gc.backoff
+}
+
 // attemptConnection tries to establish a single connection
 func (gc *GrpcCommunicator) attemptConnection() bool {
+	if gc.IsConnected() {
+		return true
+	}
+	// Tokens are fetched before taking gc.mu, as fetching one may take an HTTP request
+	// and SendEvent must keep queueing events meanwhile
+	for attempt := 0; ; attempt++ {
+		token, err := gc.currentToken()
+		if err != nil {
+			if errors.Is(err, errTokenExpired) {
+				gc.states.set(StateAuthFailed)
+			}
+			log.Printf("❌ Failed to obtain API token: %v", err)
+			return false
+		}
+		connected, retry := gc.connectWith(token)
+		// The first rejection in a row is retried right away with a refreshed token
+		if !retry || attempt > 0 {
+			return connected
+		}
+	}
+}
+
+// connectWith dials the server and opens the event stream authenticated with token.
+// retry reports a first rejection of the token, worth retrying with a fresh one.
+func (gc *GrpcCommunicator) connectWith(token Token) (connected, retry bool) {
 	gc.mu.Lock()
 	defer gc.mu.Unlock()
 
 	if gc.connected {
-		return true
+		return true, false
+	}
+
+	// Do not reconnect once Close has been called
+	if gc.ctx.Err() != nil {
+		return false, false
 	}
 
 	log.Printf("Attempting to connect to gRPC server at %s...", gc.serverAddress)
 	
 	// Warn if no API token is provided
-	if gc.apiToken == "" {
+	if gc.tokens == nil {
 		log.Printf("⚠️  Warning: No API token provided. Set SERVER_API_TOKEN environment variable for authentication.")
 	}
 
+	creds := insecure.NewCredentials()
+	if gc.tls != nil {
+		var err error
+		if creds, err = gc.tls.transportCredentials(); err != nil {
+			log.Printf("Failed to load TLS credentials: %v", err)
+			return false, false
+		}
+	} else if gc.tokens != nil {
+		log.Printf("⚠️  Warning: TLS is disabled, the API token is sent in plaintext. Set GRPC_TLS=true to encrypt the connection.")
+	}
+
 	// Create gRPC connection without blocking
 	conn, err := grpc.NewClient(
 		gc.serverAddress,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
 	)
 	if err != nil {
 		log.Printf("Failed to create gRPC client: %v", err)
-		return false
+		return false, false
 	}
 
 	// Test connection with a short timeout
@@ -157,7 +304,7 @@ func (gc *GrpcCommunicator) attemptConnection() bool {
 		if !conn.WaitForStateChange(ctx, state) {
 			conn.Close()
 			log.Printf("Connection timeout to %s", gc.serverAddress)
-			return false
+			return false, false
 		}
 
 		// Check if context was cancelled
@@ -165,74 +312,214 @@ func (gc *GrpcCommunicator) attemptConnection() bool {
 		case <-ctx.Done():
 			conn.Close()
 			log.Printf("Connection attempt cancelled")
-			return false
+			return false, false
 		default:
 		}
 
 		state = conn.GetState()
 	}
 
-	gc.client = workflowsgrpc.NewEventServiceClient(conn)
+	client := workflowsgrpc.NewEventServiceClient(conn)
+
+	stream, err := gc.openStream(client, token)
+	if err != nil {
+		conn.Close()
+		if status.Code(err) == codes.Unauthenticated {
+			return false, gc.onUnauthenticated(err)
+		}
+		log.Printf("❌ Failed to create event stream: %v", err)
+		return false, false
+	}
+
+	// Send what was queued while disconnected, before any new event
+	if err := gc.outbound.flush(func(event *types.EventMessage) error {
+		grpcMsg, err := workflowsgrpc.ConvertToGRPC(event)
+		if err != nil {
+			gc.dropped.Add(1)
+			log.Printf("Dropping queued %s event that cannot be converted: %v", event.Event, err)
+			return nil
+		}
+		return stream.Send(grpcMsg)
+	}); err != nil {
+		stream.CloseSend()
+		conn.Close()
+		log.Printf("Failed to send queued events: %v", err)
+		return false, false
+	}
+
+	// Success - store connection details
+	gc.conn = conn
+	gc.client = client
+	gc.stream = stream
+	gc.connected = true
+
+	// Start message handler
+	gc.receivers.Add(1)
+	go gc.receiveMessages(stream)
+	gc.scheduleTokenRefresh(token)
+	gc.states.set(StateConnected)
+
+	log.Printf("✅ gRPC client successfully connected to workflow server at %s", gc.serverAddress)
+	return true, false
+}
+
+// currentToken fetches the token for a new stream from the token source. An expired
+// token is replaced once by sources that can, and fails with errTokenExpired otherwise.
+func (gc *GrpcCommunicator) currentToken() (Token, error) {
+	if gc.tokens == nil {
+		return Token{}, nil
+	}
+	ctx, cancel := context.WithTimeout(gc.ctx, 30*time.Second)
+	defer cancel()
+	token, err := gc.tokens.Token(ctx)
+	if err != nil || !token.expired() {
+		return token, err
+	}
+	if source, ok := gc.tokens.(interface{ Invalidate() }); ok {
+		source.Invalidate()
+		if token, err = gc.tokens.Token(ctx); err != nil || !token.expired() {
+			return token, err
+		}
+	}
+	return Token{}, fmt.Errorf("%w at %s", errTokenExpired, token.Expiry.Format(time.RFC3339))
+}
 
+// openStream opens an event stream authenticated with token and registers the worker on it
+func (gc *GrpcCommunicator) openStream(client workflowsgrpc.EventServiceClient, token Token) (grpc.BidiStreamingClient[workflowsgrpc.GrpcEventMessage, workflowsgrpc.GrpcEventMessage], error) {
 	// Create context with authentication metadata
 	streamCtx := gc.ctx
-	if gc.apiToken != "" {
+	if token.Value != "" {
 		md := metadata.New(map[string]string{
-			"authorization": "Bearer " + gc.apiToken,
+			"authorization": "Bearer " + token.Value,
 		})
 		streamCtx = metadata.NewOutgoingContext(streamCtx, md)
 	}
 
 	// Create bidirectional stream with authentication metadata
-	stream, err := gc.client.Events(streamCtx)
+	stream, err := client.Events(streamCtx)
 	if err != nil {
-		conn.Close()
-		// Check if this is an authentication error
-		if st, ok := status.FromError(err); ok && st.Code() == codes.Unauthenticated {
-			if gc.apiToken == "" {
-				log.Printf("❌ Authentication failed: No API token provided. Set SERVER_API_TOKEN environment variable.")
-			} else {
-				log.Printf("❌ Authentication failed: Invalid or expired API token. Error: %v", st.Message())
-			}
-		} else {
-			log.Printf("❌ Failed to create event stream: %v", err)
-		}
-		return false
+		return nil, err
 	}
 
 	// Send initial registration message
-	registrationMsg := &workflowsgrpc.GrpcEventMessage{
-		Server:        gc.serverName,
-		Event:         types.EventClientRegistration,
-		Text:          "Client registration",
-		CorrelationId: "",
+	registration := &types.EventMessage{
+		Server: gc.serverName,
+		Event:  types.EventClientRegistration,
+		Text:   "Client registration",
+	}
+	if meta := gc.registrationMeta.Load(); meta != nil {
+		if values := (*meta)(); values != nil {
+			registration.Meta = &values
+		}
+	}
+	registrationMsg, err := workflowsgrpc.ConvertToGRPC(registration)
+	if err != nil {
+		stream.CloseSend()
+		return nil, fmt.Errorf("failed to convert registration: %w", err)
 	}
 
 	if err := stream.Send(registrationMsg); err != nil {
-		conn.Close()
-		log.Printf("Failed to register with server: %v", err)
+		stream.CloseSend()
+		return nil, fmt.Errorf("failed to register with server: %w", err)
+	}
+	return stream, nil
+}
+
+// onUnauthenticated logs a rejected token and invalidates it so the next stream uses a
+// fresh one. It reports whether this is the first rejection in a row, which is retried
+// without waiting.
+func (gc *GrpcCommunicator) onUnauthenticated(err error) bool {
+	gc.states.set(StateAuthFailed)
+	if gc.tokens == nil {
+		log.Printf("❌ Authentication failed: No API token provided. Set SERVER_API_TOKEN environment variable.")
 		return false
 	}
+	log.Printf("❌ Authentication failed: Invalid or expired API token. Error: %v", status.Convert(err).Message())
+	if source, ok := gc.tokens.(interface{ Invalidate() }); ok {
+		source.Invalidate()
+	}
+	return gc.authFailures.Add(1) == 1
+}
 
-	// Success - store connection details
-	gc.conn = conn
-	gc.stream = stream
-	gc.connected = true
+// scheduleTokenRefresh arranges for the stream to be re-established before token
+// expires. Callers hold gc.mu.
+func (gc *GrpcCommunicator) scheduleTokenRefresh(token Token) {
+	if gc.refreshTimer != nil {
+		gc.refreshTimer.Stop()
+		gc.refreshTimer = nil
+	}
+	gc.streamExpiry = token.Expiry
+	if token.Expiry.IsZero() {
+		return
+	}
+	// A static token never changes, so a new stream would not outlive the current one
+	if _, static := gc.tokens.(staticTokenSource); static {
+		return
+	}
+	remaining := time.Until(token.Expiry)
+	delay := remaining - tokenRefreshMargin
+	if delay < remaining/2 {
+		// Short-lived token: refresh halfway through its lifetime
+		delay = remaining / 2
+	}
+	if delay < minTokenRefreshDelay {
+		delay = minTokenRefreshDelay
+	}
+	gc.refreshTimer = time.AfterFunc(delay, gc.refreshStream)
+}
 
-	// Start message handler
-	go gc.receiveMessages()
+// refreshStream replaces the event stream with one authenticated by a fresh token.
+// The new stream is registered before the old one is closed, and the old stream's
+// receiver keeps delivering responses until the server ends it.
+func (gc *GrpcCommunicator) refreshStream() {
+	token, err := gc.currentToken()
 
-	log.Printf("✅ gRPC client successfully connected to workflow server at %s", gc.serverAddress)
-	return true
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	if !gc.connected || gc.ctx.Err() != nil {
+		return
+	}
+	retry := gc.backoff.Initial
+	if err != nil {
+		log.Printf("Failed to refresh API token, retrying in %v: %v", retry, err)
+		gc.refreshTimer = time.AfterFunc(retry, gc.refreshStream)
+		return
+	}
+	// Only a token outliving the current one is worth a new stream
+	if !token.Expiry.IsZero() && !token.Expiry.After(gc.streamExpiry) {
+		log.Printf("No newer API token available yet, retrying in %v", retry)
+		gc.refreshTimer = time.AfterFunc(retry, gc.refreshStream)
+		return
+	}
+	stream, err := gc.openStream(gc.client, token)
+	if err != nil {
+		log.Printf("Failed to re-establish event stream, retrying in %v: %v", retry, err)
+		if status.Code(err) == codes.Unauthenticated {
+			gc.onUnauthenticated(err)
+		}
+		gc.refreshTimer = time.AfterFunc(retry, gc.refreshStream)
+		return
+	}
+
+	previous := gc.stream
+	gc.stream = stream
+	gc.receivers.Add(1)
+	go gc.receiveMessages(stream)
+	previous.CloseSend()
+	gc.scheduleTokenRefresh(token)
+
+	log.Printf("🔑 Re-established event stream with a refreshed API token")
 }
 
-// SendEvent sends an event to the workflow server via gRPC
+// SendEvent sends an event to the workflow server via gRPC. Events that cannot be sent
+// are queued, dropped or rejected according to their outbound policy.
 func (gc *GrpcCommunicator) SendEvent(event *types.EventMessage) error {
 	gc.mu.RLock()
 	defer gc.mu.RUnlock()
 
 	if !gc.connected || gc.stream == nil {
-		return fmt.Errorf("not connected to workflow server")
+		return gc.holdEvent(event, ErrNotConnected)
 	}
 
 	// Convert to gRPC format
@@ -241,28 +528,53 @@ func (gc *GrpcCommunicator) SendEvent(event *types.EventMessage) error {
 		return fmt.Errorf("failed to convert event to gRPC format: %w", err)
 	}
 
-	// Send the message
-	if err := gc.stream.Send(grpcMsg); err != nil {
+	// Send the message; a gRPC stream must not be sent on from several goroutines at once
+	gc.sendMu.Lock()
+	err = gc.stream.Send(grpcMsg)
+	gc.sendMu.Unlock()
+	if err != nil {
 		log.Printf("Failed to send event via gRPC: %v", err)
 		// Trigger reconnection
 		select {
 		case gc.reconnectCh <- struct{}{}:
 		default:
 		}
-		return fmt.Errorf("failed to send event: %w", err)
+		return gc.holdEvent(event, fmt.Errorf("failed to send event: %w", err))
 	}
 
 	log.Printf("Sent event via gRPC: %s", event.Event)
 	return nil
 }
 
+// holdEvent applies the outbound policy to an event that could not be sent because of err
+func (gc *GrpcCommunicator) holdEvent(event *types.EventMessage, err error) error {
+	switch gc.outbound.policy(event.Event) {
+	case PolicyQueue:
+		if gc.outbound.config.Size <= 0 {
+			return err
+		}
+		if queueErr := gc.outbound.push(event); queueErr != nil {
+			gc.dropped.Add(1)
+			return fmt.Errorf("%w (%w)", err, queueErr)
+		}
+		log.Printf("Queued event %s until the connection is re-established", event.Event)
+		return nil
+	case PolicyDrop:
+		gc.dropped.Add(1)
+		log.Printf("Dropped event %s while disconnected", event.Event)
+		return nil
+	}
+	return err
+}
+
 // ReceiveEvents returns a channel for receiving events from gRPC
 func (gc *GrpcCommunicator) ReceiveEvents() <-chan *types.EventMessage {
 	return gc.incomingEvents
 }
 
-// receiveMessages handles incoming messages from the server
-func (gc *GrpcCommunicator) receiveMessages() {
+// receiveMessages handles incoming messages from the server on stream
+func (gc *GrpcCommunicator) receiveMessages(stream grpc.BidiStreamingClient[workflowsgrpc.GrpcEventMessage, workflowsgrpc.GrpcEventMessage]) {
+	defer gc.receivers.Done()
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Panic in receiveMessages: %v", r)
@@ -276,20 +588,20 @@ func (gc *GrpcCommunicator) receiveMessages() {
 		default:
 		}
 
-		gc.mu.RLock()
-		stream := gc.stream
-		connected := gc.connected
-		gc.mu.RUnlock()
-
-		if !connected || stream == nil {
-			time.Sleep(1 * time.Second)
-			continue
-		}
-
 		msg, err := stream.Recv()
 		if err != nil {
+			gc.mu.RLock()
+			current := gc.stream == stream
+			gc.mu.RUnlock()
+			if !current {
+				// Replaced by a token refresh or already disconnected
+				return
+			}
+
 			if err == io.EOF {
 				log.Println("gRPC stream closed by server")
+			} else if status.Code(err) == codes.Unauthenticated {
+				gc.onUnauthenticated(err)
 			} else {
 				log.Printf("Error receiving gRPC message: %v", err)
 			}
@@ -301,25 +613,37 @@ func (gc *GrpcCommunicator) receiveMessages() {
 			}
 			return
 		}
+		gc.authFailures.Store(0)
 
 		// Convert from gRPC format
 		eventMsg, err := workflowsgrpc.ConvertFromGRPC(msg)
 		if err != nil {
+			gc.dropped.Add(1)
 			log.Printf("Failed to convert gRPC message: %v", err)
 			continue
 		}
+		if handle := gc.responseHandler.Load(); handle != nil && (*handle)(eventMsg) {
+			continue
+		}
 
-		// Send to incoming events channel (non-blocking)
+		// Hand the event over, waiting while the channel is full. Not reading from the
+		// stream meanwhile applies gRPC flow control to the server instead of dropping.
 		select {
 		case gc.incomingEvents <- eventMsg:
-			log.Printf("Received event via gRPC: %s", eventMsg.Event)
 		default:
-			log.Printf("Incoming events channel full, dropping message: %s", eventMsg.Event)
+			log.Printf("Incoming events channel full, pausing reads from the stream")
+			select {
+			case <-gc.ctx.Done():
+				return
+			case gc.incomingEvents <- eventMsg:
+			}
 		}
+		log.Printf("Received event via gRPC: %s", eventMsg.Event)
 	}
 }
 
-// connectionMonitor monitors connection health and handles reconnection
+// connectionMonitor monitors connection health and handles reconnection. It runs
+// once for the lifetime of the communicator.
 func (gc *GrpcCommunicator) connectionMonitor() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -368,9 +692,12 @@ func (gc *GrpcCommunicator) handleReconnection() {
 	log.Println("Connection lost, attempting to reconnect...")
 
 	gc.disconnect()
+	if gc.states.current() != StateAuthFailed {
+		gc.states.set(StateReconnecting)
+	}
 
-	// Start the connection loop again (it will retry every 5 seconds)
-	go gc.connectionLoop()
+	// Retry at once only after the first rejected token, which has just been refreshed
+	go gc.connectionLoop(gc.authFailures.Load() == 1)
 }
 
 // disconnect closes the current connection
@@ -380,6 +707,11 @@ func (gc *GrpcCommunicator) disconnect() {
 
 	gc.connected = false
 
+	if gc.refreshTimer != nil {
+		gc.refreshTimer.Stop()
+		gc.refreshTimer = nil
+	}
+
 	if gc.stream != nil {
 		gc.stream.CloseSend()
 		gc.stream = nil
@@ -391,13 +723,24 @@ func (gc *GrpcCommunicator) disconnect() {
 	}
 }
 
-// Close closes the gRPC communicator and cleans up resources
+// Close closes the gRPC communicator and cleans up resources.
+// The incoming events channel is closed only after every receiver goroutine has
+// exited, so it is never written to after being closed. Close is idempotent.
 func (gc *GrpcCommunicator) Close() error {
-	gc.cancel()
-	gc.disconnect()
-	close(gc.incomingEvents)
+	gc.closeOnce.Do(func() {
+		gc.cancel()
+		gc.disconnect()
+		gc.receivers.Wait()
+		close(gc.incomingEvents)
+		gc.states.set(StateClosed)
+
+		if pending := gc.outbound.len(); pending > 0 && gc.outbound.path == "" {
+			log.Printf("⚠️  Closing with %d queued events that were never sent", pending)
+		}
+		gc.outbound.close()
 
-	log.Println("gRPC client closed")
+		log.Println("gRPC client closed")
+	})
 	return nil
 }
 
@@ -408,4 +751,21 @@ func (gc *GrpcCommunicator) IsConnected() bool {
 	return gc.connected
 }
 
+// DroppedEvents returns how many events were discarded: dropped by their outbound
+// policy, refused by a full outbound queue or received in an unreadable form
+func (gc *GrpcCommunicator) DroppedEvents() uint64 {
+	return gc.dropped.Load()
+}
+
+// State returns the current connection state
+func (gc *GrpcCommunicator) State() ConnectionState {
+	return gc.states.current()
+}
+
+// SubscribeState returns a channel of connection state changes and a function ending
+// the subscription
+func (gc *GrpcCommunicator) SubscribeState() (<-chan ConnectionState, func()) {
+	return gc.states.subscribe()
+}
+
 // conversion helpers removed in favor of workflowsgrpc converters
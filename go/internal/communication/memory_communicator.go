@@ -0,0 +1,153 @@
+package communication
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+// MemoryCommunicator is an in-process WorkflowCommunicator without network sockets.
+// A standalone communicator exposes the events it sends on Outbound and receives the
+// events passed to Inject. Two communicators created with NewMemoryPair are wired
+// back to back: whatever one side sends, the other receives.
+type MemoryCommunicator struct {
+	mu       sync.RWMutex
+	closed   bool
+	incoming chan *types.EventMessage
+	outbound chan *types.EventMessage
+	peer     *MemoryCommunicator
+	states   *stateNotifier
+	// responseHandler is offered injected events before they are queued
+	responseHandler atomic.Pointer[func(*types.EventMessage) bool]
+}
+
+// NewMemoryCommunicator creates a standalone communicator whose channels hold up to buffer events
+func NewMemoryCommunicator(buffer int) *MemoryCommunicator {
+	if buffer <= 0 {
+		buffer = 100
+	}
+	return &MemoryCommunicator{
+		incoming: make(chan *types.EventMessage, buffer),
+		outbound: make(chan *types.EventMessage, buffer),
+		states:   newStateNotifier(StateConnected),
+	}
+}
+
+// NewMemoryPair creates two connected communicators, e.g. a worker and a fake workflow server
+func NewMemoryPair(buffer int) (*MemoryCommunicator, *MemoryCommunicator) {
+	a, b := NewMemoryCommunicator(buffer), NewMemoryCommunicator(buffer)
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+// SendEvent delivers a copy of event to the peer, or to Outbound for a standalone communicator
+func (m *MemoryCommunicator) SendEvent(event *types.EventMessage) error {
+	if !m.IsConnected() {
+		return ErrNotConnected
+	}
+	if m.peer != nil {
+		return m.peer.Inject(event)
+	}
+	return m.deliver(m.outbound, event)
+}
+
+// ReceiveEvents returns the channel of received events, which is closed by Close
+func (m *MemoryCommunicator) ReceiveEvents() <-chan *types.EventMessage {
+	return m.incoming
+}
+
+// Inject delivers a copy of event to ReceiveEvents as if it came from the workflow server
+func (m *MemoryCommunicator) Inject(event *types.EventMessage) error {
+	if handle := m.responseHandler.Load(); handle != nil && (*handle)(cloneEvent(event)) {
+		return nil
+	}
+	return m.deliver(m.incoming, event)
+}
+
+// SetResponseHandler installs a function offered every injected event before it is
+// queued on ReceiveEvents; events it returns true for are consumed
+func (m *MemoryCommunicator) SetResponseHandler(handler func(*types.EventMessage) bool) {
+	m.responseHandler.Store(&handler)
+}
+
+// Outbound returns the events sent by a standalone communicator. It is never closed.
+func (m *MemoryCommunicator) Outbound() <-chan *types.EventMessage {
+	return m.outbound
+}
+
+// deliver copies event into ch without blocking
+func (m *MemoryCommunicator) deliver(ch chan *types.EventMessage, event *types.EventMessage) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ErrNotConnected
+	}
+	select {
+	case ch <- cloneEvent(event):
+		return nil
+	default:
+		return ErrChannelFull
+	}
+}
+
+// Close stops the communicator and closes its receive channel. The peer can no longer
+// send either, so its state becomes closed as well.
+func (m *MemoryCommunicator) Close() error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.incoming)
+	}
+	m.mu.Unlock()
+
+	m.states.set(StateClosed)
+	if m.peer != nil {
+		m.peer.states.set(StateClosed)
+	}
+	return nil
+}
+
+// IsConnected reports whether the communicator, and its peer if any, are open
+func (m *MemoryCommunicator) IsConnected() bool {
+	m.mu.RLock()
+	closed := m.closed
+	m.mu.RUnlock()
+	if closed {
+		return false
+	}
+	if m.peer != nil {
+		m.peer.mu.RLock()
+		defer m.peer.mu.RUnlock()
+		return !m.peer.closed
+	}
+	return true
+}
+
+// State returns StateConnected until either side of the pair is closed
+func (m *MemoryCommunicator) State() ConnectionState {
+	return m.states.current()
+}
+
+// SubscribeState returns a channel of connection state changes and a function ending
+// the subscription
+func (m *MemoryCommunicator) SubscribeState() (<-chan ConnectionState, func()) {
+	return m.states.subscribe()
+}
+
+// cloneEvent copies an event so neither side sees later changes to the other's payload or meta
+func cloneEvent(event *types.EventMessage) *types.EventMessage {
+	clone := *event
+	if event.Payload != nil {
+		payload := append([]byte(nil), *event.Payload...)
+		clone.Payload = &payload
+	}
+	if event.Meta != nil {
+		meta := make(map[string]any, len(*event.Meta))
+		for key, value := range *event.Meta {
+			meta[key] = value
+		}
+		clone.Meta = &meta
+	}
+	return &clone
+}
@@ -0,0 +1,82 @@
+package communication_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/grpccache"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+func TestMemoryPairCarriesCacheRoundTrip(t *testing.T) {
+	workerSide, serverSide := communication.NewMemoryPair(10)
+	cache := grpccache.NewClient(workerSide, "worker", nil)
+
+	// Fake workflow server: answer cache gets, and route responses back to the client
+	go func() {
+		for msg := range serverSide.ReceiveEvents() {
+			if msg.Event == types.EventCacheGetRequest && (*msg.Meta)["Key"] == "forbidden" {
+				sdkErr := types.NewError(types.ErrorCodeInvalidInput, "key is reserved")
+				reply := &types.EventMessage{Event: types.EventError, Text: sdkErr.Message, CorrelationID: msg.CorrelationID}
+				sdkErr.ToEvent(reply)
+				serverSide.SendEvent(reply)
+			} else if msg.Event == types.EventCacheGetRequest {
+				value := []byte("cached:" + (*msg.Meta)["Key"].(string))
+				serverSide.SendEvent(&types.EventMessage{Event: types.EventCacheGetResponse, Payload: &value, CorrelationID: msg.CorrelationID})
+			}
+		}
+	}()
+	go func() {
+		for msg := range workerSide.ReceiveEvents() {
+			cache.HandleResponse(*msg)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	value, err := cache.GetByString(ctx, "answer")
+	if err != nil || string(value) != "cached:answer" {
+		t.Fatalf("unexpected cache result %q, %v", value, err)
+	}
+	// An error answering the request fails it right away with the remote code and text
+	_, err = cache.GetByString(ctx, "forbidden")
+	if sdkErr := types.AsError(err); sdkErr == nil || sdkErr.Code != types.ErrorCodeInvalidInput || sdkErr.Message != "key is reserved" {
+		t.Fatalf("expected the remote error, got %v", err)
+	}
+
+	serverSide.Close()
+	if workerSide.IsConnected() {
+		t.Fatalf("closing one side must disconnect the other")
+	}
+	if err := workerSide.SendEvent(&types.EventMessage{}); !errors.Is(err, communication.ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+	workerSide.Close()
+}
+
+func TestMemoryCommunicatorCopiesEvents(t *testing.T) {
+	comm := communication.NewMemoryCommunicator(1)
+	payload := []byte("original")
+	if err := comm.SendEvent(&types.EventMessage{Event: "test", Payload: &payload}); err != nil {
+		t.Fatal(err)
+	}
+	payload[0] = 'X'
+
+	sent := <-comm.Outbound()
+	if string(*sent.Payload) != "original" {
+		t.Fatalf("payload changed after send: %q", *sent.Payload)
+	}
+
+	if err := comm.Inject(&types.EventMessage{Event: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := comm.Inject(&types.EventMessage{Event: "two"}); !errors.Is(err, communication.ErrChannelFull) {
+		t.Fatalf("expected ErrChannelFull, got %v", err)
+	}
+	if received := <-comm.ReceiveEvents(); received.Event != "one" {
+		t.Fatalf("unexpected event %q", received.Event)
+	}
+}
@@ -0,0 +1,261 @@
+package communication
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+// OutboundPolicy decides what SendEvent does with an event while disconnected
+type OutboundPolicy int
+
+const (
+	// PolicyReject returns ErrNotConnected so the caller can fail fast
+	PolicyReject OutboundPolicy = iota
+	// PolicyQueue holds the event and sends it after reconnecting
+	PolicyQueue
+	// PolicyDrop discards the event without an error
+	PolicyDrop
+)
+
+// DefaultOutboundPolicies queue results and writes that would otherwise be lost, drop status
+// messages and reject requests, whose callers are waiting for an answer
+var DefaultOutboundPolicies = map[string]OutboundPolicy{
+	types.EventFunctionResponse:      PolicyQueue,
+	types.EventFunctionChunk:         PolicyQueue,
+	types.EventError:                 PolicyQueue,
+	types.EventResponseListFunctions: PolicyQueue,
+	types.EventResponseServerName:    PolicyQueue,
+	types.EventCacheSet:              PolicyQueue,
+	types.EventStoreSetRequest:       PolicyQueue,
+	types.EventStatusMessage:         PolicyDrop,
+}
+
+// OutboundQueueConfig configures the events held while disconnected
+type OutboundQueueConfig struct {
+	// Size bounds the number of queued events; 0 disables queueing
+	Size int
+	// Dir, when set, persists queued events to a file there, so they survive a restart
+	Dir string
+	// Policies override DefaultOutboundPolicies per event type; unlisted events are rejected
+	Policies map[string]OutboundPolicy
+}
+
+// DefaultOutboundQueueSize is the queue size used unless configured otherwise
+const DefaultOutboundQueueSize = 1000
+
+// maxOutboundQueueFiles bounds how many running workers with the same server name can
+// persist their queues to the same directory
+const maxOutboundQueueFiles = 64
+
+// errLocked is returned by lockFile when another queue holds the lock
+var errLocked = errors.New("file is locked")
+
+// outboundQueue is a bounded FIFO of events waiting for a connection, optionally
+// mirrored to a JSON lines file that the queue holds a lock on
+type outboundQueue struct {
+	config OutboundQueueConfig
+	path   string
+	lock   *os.File
+
+	mu     sync.Mutex
+	events []*types.EventMessage
+}
+
+// newOutboundQueue creates a queue and loads events persisted by a previous run.
+// Every running queue locks its own file, so workers sharing a server name and a
+// directory never write to the same file: the first file not locked by a running
+// worker is taken over, starting with outbound-<server name>.jsonl.
+func newOutboundQueue(config OutboundQueueConfig, serverName string) (*outboundQueue, error) {
+	q := &outboundQueue{config: config}
+	if config.Dir == "" || config.Size <= 0 {
+		return q, nil
+	}
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("outbound queue: %w", err)
+	}
+	for n := 0; q.lock == nil; n++ {
+		if n == maxOutboundQueueFiles {
+			return nil, fmt.Errorf("outbound queue: all %d queue files of %s in %s are in use", maxOutboundQueueFiles, serverName, config.Dir)
+		}
+		path := filepath.Join(config.Dir, queueFileName(serverName, n))
+		lock, err := lockFile(path + ".lock")
+		if errors.Is(err, errLocked) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("outbound queue: %w", err)
+		}
+		q.path, q.lock = path, lock
+	}
+	if err := q.load(); err != nil {
+		q.close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// queueFileName names the n-th queue file of a server
+func queueFileName(serverName string, n int) string {
+	if n == 0 {
+		return "outbound-" + serverName + ".jsonl"
+	}
+	return fmt.Sprintf("outbound-%s.%d.jsonl", serverName, n)
+}
+
+// load reads the events persisted in the queue's file
+func (q *outboundQueue) load() error {
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("outbound queue: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var event types.EventMessage
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			log.Printf("Skipping unreadable queued event in %s: %v", q.path, err)
+			continue
+		}
+		q.events = append(q.events, &event)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("outbound queue: %w", err)
+	}
+	if len(q.events) > 0 {
+		log.Printf("Loaded %d queued events from %s", len(q.events), q.path)
+	}
+	return nil
+}
+
+// close releases the queue's file for the next run; the events stay in the file
+func (q *outboundQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.lock != nil {
+		q.lock.Close()
+		q.lock = nil
+	}
+}
+
+// policy returns the policy for an event type
+func (q *outboundQueue) policy(event string) OutboundPolicy {
+	if policy, ok := q.config.Policies[event]; ok {
+		return policy
+	}
+	return DefaultOutboundPolicies[event]
+}
+
+// push appends an event, failing with ErrQueueFull when the queue is at capacity
+func (q *outboundQueue) push(event *types.EventMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.events) >= q.config.Size {
+		return ErrQueueFull
+	}
+	event = cloneEvent(event)
+	if q.path != "" {
+		if err := q.appendToFile(event); err != nil {
+			return err
+		}
+	}
+	q.events = append(q.events, event)
+	return nil
+}
+
+// flush sends queued events in order until send fails. Sent events are removed,
+// the rest stay queued for the next connection.
+func (q *outboundQueue) flush(send func(*types.EventMessage) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.events) == 0 {
+		return nil
+	}
+	sent := 0
+	var err error
+	for _, event := range q.events {
+		if err = send(event); err != nil {
+			break
+		}
+		sent++
+	}
+	q.events = append(q.events[:0], q.events[sent:]...)
+	if sent > 0 {
+		log.Printf("Flushed %d queued events, %d remaining", sent, len(q.events))
+	}
+	if q.path != "" {
+		if rewriteErr := q.rewriteFile(); rewriteErr != nil {
+			log.Printf("Failed to update %s: %v", q.path, rewriteErr)
+		}
+	}
+	return err
+}
+
+// len returns the number of queued events
+func (q *outboundQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events)
+}
+
+// appendToFile persists one event. Callers hold q.mu.
+func (q *outboundQueue) appendToFile(event *types.EventMessage) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("outbound queue: %w", err)
+	}
+	file, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("outbound queue: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("outbound queue: %w", err)
+	}
+	return nil
+}
+
+// rewriteFile replaces the file with the events still queued. Callers hold q.mu.
+func (q *outboundQueue) rewriteFile() error {
+	if len(q.events) == 0 {
+		if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	tmp := q.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, event := range q.events {
+		if err := encoder.Encode(event); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
@@ -0,0 +1,141 @@
+package communication
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/workflowsgrpc"
+	"google.golang.org/grpc"
+)
+
+// recordingServer forwards the name of every event it receives
+type recordingServer struct {
+	workflowsgrpc.UnimplementedEventServiceServer
+	events chan string
+}
+
+func (s *recordingServer) Events(stream grpc.BidiStreamingServer[workflowsgrpc.GrpcEventMessage, workflowsgrpc.GrpcEventMessage]) error {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		s.events <- msg.Event + ":" + msg.CorrelationId
+	}
+}
+
+func TestGrpcCommunicatorQueuesEventsUntilRegistered(t *testing.T) {
+	gc := NewGrpcCommunicatorWithOptions("127.0.0.1:0", "queue-worker", "", 10, 1, 30)
+	defer gc.Close()
+
+	for _, event := range []*types.EventMessage{
+		{Event: types.EventFunctionChunk, CorrelationID: "0"},
+		{Event: types.EventFunctionResponse, CorrelationID: "1"},
+		{Event: types.EventStatusMessage, CorrelationID: "2"},
+		{Event: types.EventError, CorrelationID: "3"},
+		{Event: types.EventStoreSetRequest, CorrelationID: "4"},
+	} {
+		if err := gc.SendEvent(event); err != nil {
+			t.Fatalf("%s: %v", event.Event, err)
+		}
+	}
+	if err := gc.SendEvent(&types.EventMessage{Event: types.EventCacheGetRequest}); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected requests to be rejected, got %v", err)
+	}
+
+	server := &recordingServer{events: make(chan string, 10)}
+	grpcServer := grpc.NewServer()
+	workflowsgrpc.RegisterEventServiceServer(grpcServer, server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	gc.serverAddress = listener.Addr().String()
+	gc.Connect()
+
+	for _, want := range []string{"client_registration:", "function_chunk:0", "function_response:1", "error:3", "store_set_request:4"} {
+		select {
+		case got := <-server.events:
+			if got != want {
+				t.Fatalf("expected %s, got %s", want, got)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("did not receive %s", want)
+		}
+	}
+}
+
+func TestOutboundQueuePersistsAcrossRestarts(t *testing.T) {
+	config := OutboundQueueConfig{Size: 2, Dir: t.TempDir()}
+	queue, err := newOutboundQueue(config, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(`{"ok":true}`)
+	queue.push(&types.EventMessage{Event: types.EventFunctionResponse, CorrelationID: "a", Payload: &payload})
+	queue.push(&types.EventMessage{Event: types.EventFunctionResponse, CorrelationID: "b"})
+	if err := queue.push(&types.EventMessage{Event: types.EventFunctionResponse}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	queue.close()
+
+	restarted, err := newOutboundQueue(config, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	restarted.flush(func(event *types.EventMessage) error {
+		if event.CorrelationID == "b" {
+			return errors.New("stream broken")
+		}
+		sent = append(sent, event.CorrelationID+string(*event.Payload))
+		return nil
+	})
+	if len(sent) != 1 || sent[0] != `a{"ok":true}` || restarted.len() != 1 {
+		t.Fatalf("unexpected flush %v with %d remaining", sent, restarted.len())
+	}
+
+	restarted.flush(func(*types.EventMessage) error { return nil })
+	if _, err := os.Stat(restarted.path); !os.IsNotExist(err) {
+		t.Fatalf("expected the queue file to be removed once empty, got %v", err)
+	}
+}
+
+func TestOutboundQueuesOfRunningWorkersUseSeparateFiles(t *testing.T) {
+	config := OutboundQueueConfig{Size: 10, Dir: t.TempDir()}
+	first, err := newOutboundQueue(config, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newOutboundQueue(config, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.path == second.path {
+		t.Fatalf("both queues write to %s", first.path)
+	}
+	first.push(&types.EventMessage{Event: types.EventFunctionResponse, CorrelationID: "first"})
+	second.push(&types.EventMessage{Event: types.EventFunctionResponse, CorrelationID: "second"})
+
+	// The next run takes over the file of the worker that stopped, and only that one
+	second.close()
+	next, err := newOutboundQueue(config, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	next.flush(func(event *types.EventMessage) error {
+		sent = append(sent, event.CorrelationID)
+		return nil
+	})
+	if len(sent) != 1 || sent[0] != "second" || first.len() != 1 {
+		t.Fatalf("expected only the stopped worker's events to be taken over, got %v", sent)
+	}
+}
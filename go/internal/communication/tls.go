@@ -0,0 +1,138 @@
+package communication
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig configures transport security for the connection to the workflow server
+type TLSConfig struct {
+	// Enabled turns on TLS. Without CAFile the system roots verify the server.
+	Enabled bool
+	// CAFile is a PEM bundle of CAs trusted instead of the system roots
+	CAFile string
+	// CertFile and KeyFile hold the PEM client certificate and key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name the server certificate is verified against
+	ServerName string
+}
+
+// newTLSReloader validates the configuration by loading its files once
+func newTLSReloader(c TLSConfig) (*tlsReloader, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("tls: client certificate and key must be set together")
+	}
+	r := &tlsReloader{config: c}
+	if c.CertFile != "" {
+		if _, err := r.clientCertificate(nil); err != nil {
+			return nil, err
+		}
+	}
+	if c.CAFile != "" {
+		if _, err := r.rootCAs(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// tlsReloader caches the client certificate and CA bundle and reloads them when
+// the modification time of their files changes. The CA bundle is read when dialing,
+// the client certificate on every handshake, so rotated files are picked up without
+// restarting the worker.
+type tlsReloader struct {
+	config TLSConfig
+
+	mu           sync.Mutex
+	certificate  *tls.Certificate
+	certModTimes [2]time.Time
+	pool         *x509.CertPool
+	caModTime    time.Time
+}
+
+// transportCredentials returns the credentials for the next connection attempt
+func (r *tlsReloader) transportCredentials() (credentials.TransportCredentials, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: r.config.ServerName,
+	}
+	if r.config.CertFile != "" {
+		tlsConfig.GetClientCertificate = r.clientCertificate
+	}
+	if r.config.CAFile != "" {
+		pool, err := r.rootCAs()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// clientCertificate implements tls.Config.GetClientCertificate
+func (r *tlsReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certInfo, err := os.Stat(r.config.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: client certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: client key: %w", err)
+	}
+	modTimes := [2]time.Time{certInfo.ModTime(), keyInfo.ModTime()}
+	if r.certificate != nil && modTimes[0].Equal(r.certModTimes[0]) && modTimes[1].Equal(r.certModTimes[1]) {
+		return r.certificate, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		if r.certificate != nil {
+			// Keep the previous certificate while a rotation is only half written
+			return r.certificate, nil
+		}
+		return nil, fmt.Errorf("tls: failed to load client certificate: %w", err)
+	}
+	r.certificate = &certificate
+	r.certModTimes = modTimes
+	return r.certificate, nil
+}
+
+// rootCAs returns the CA pool, reloading CAFile when it changed
+func (r *tlsReloader) rootCAs() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: CA bundle: %w", err)
+	}
+	if r.pool != nil && info.ModTime().Equal(r.caModTime) {
+		return r.pool, nil
+	}
+
+	pem, err := os.ReadFile(r.config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, fmt.Errorf("tls: no certificates found in %s", r.config.CAFile)
+	}
+	r.pool = pool
+	r.caModTime = info.ModTime()
+	return r.pool, nil
+}
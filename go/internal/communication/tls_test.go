@@ -0,0 +1,170 @@
+package communication
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/workflowsgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// registrationServer records the server name of the first client_registration
+type registrationServer struct {
+	workflowsgrpc.UnimplementedEventServiceServer
+	registered chan string
+}
+
+func (s *registrationServer) Events(stream grpc.BidiStreamingServer[workflowsgrpc.GrpcEventMessage, workflowsgrpc.GrpcEventMessage]) error {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		if msg.Event == types.EventClientRegistration {
+			s.registered <- msg.Server
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGrpcCommunicatorMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writeFile(t, caFile, ca.pem)
+	writeFile(t, certFile, clientCert)
+	writeFile(t, keyFile, clientKey)
+
+	// Server that only accepts clients with a certificate from the CA
+	serverPair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	server := &registrationServer{registered: make(chan string, 1)}
+	workflowsgrpc.RegisterEventServiceServer(grpcServer, server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	gc := NewGrpcCommunicatorWithOptions(listener.Addr().String(), "tls-worker", "", 10, 1, 30)
+	if err := gc.SetTLSConfig(TLSConfig{Enabled: true, CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	gc.Connect()
+	defer gc.Close()
+
+	select {
+	case name := <-server.registered:
+		if name != "tls-worker" {
+			t.Fatalf("unexpected registration %q", name)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("worker did not register over mutual TLS")
+	}
+
+	// A rotated client certificate is used for the next handshake
+	rotatedCert, rotatedKey := ca.issue(t, 4, x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, rotatedCert)
+	writeFile(t, keyFile, rotatedKey)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+
+	current, err := gc.tls.clientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(current.Certificate[0])
+	if leaf.SerialNumber.Int64() != 4 {
+		t.Fatalf("expected the rotated certificate, got serial %v", leaf.SerialNumber)
+	}
+}
+
+func TestSetTLSConfigRejectsIncompleteClientCertificate(t *testing.T) {
+	gc := NewGrpcCommunicator("localhost:0", "worker", "")
+	if err := gc.SetTLSConfig(TLSConfig{Enabled: true, CertFile: "client.pem"}); err == nil {
+		t.Fatal("expected an error for a certificate without key")
+	}
+}
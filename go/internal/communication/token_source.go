@@ -0,0 +1,214 @@
+package communication
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before expiry a token is considered stale, both
+// by the token sources and by the communicator re-establishing its stream
+const tokenRefreshMargin = time.Minute
+
+// minTokenRefreshDelay keeps a stream from being re-established more often than this,
+// however close to expiry its token is
+const minTokenRefreshDelay = time.Second
+
+// errTokenExpired is returned when the token source only has an expired token
+var errTokenExpired = errors.New("API token has expired")
+
+// Token is a bearer token for the workflow server
+type Token struct {
+	Value string
+	// Expiry is when the token stops being valid; zero means it does not expire
+	Expiry time.Time
+}
+
+// expired reports whether the token is past its expiry
+func (t Token) expired() bool {
+	return !t.Expiry.IsZero() && !time.Now().Before(t.Expiry)
+}
+
+// TokenSource supplies the bearer token attached to the event stream. Sources that can
+// replace a token the server rejected also implement Invalidate, which makes the next
+// Token call fetch a fresh one.
+type TokenSource interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// staticTokenSource always returns the same token
+type staticTokenSource struct {
+	token Token
+}
+
+// NewStaticTokenSource returns a source for a fixed token. The expiry of JWTs is read
+// from their exp claim.
+func NewStaticTokenSource(token string) TokenSource {
+	return staticTokenSource{token: Token{Value: token, Expiry: jwtExpiry(token)}}
+}
+
+func (s staticTokenSource) Token(context.Context) (Token, error) {
+	return s.token, nil
+}
+
+// FileTokenSource reads the token from a file, e.g. a mounted Kubernetes secret, and
+// re-reads it whenever the file changes
+type FileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	token   Token
+	modTime time.Time
+}
+
+// NewFileTokenSource returns a source reading the token from path
+func NewFileTokenSource(path string) *FileTokenSource {
+	return &FileTokenSource{path: path}
+}
+
+// Token returns the file's current content with surrounding whitespace removed
+func (s *FileTokenSource) Token(context.Context) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return Token{}, fmt.Errorf("token file: %w", err)
+	}
+	if s.token.Value != "" && info.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return Token{}, fmt.Errorf("token file: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return Token{}, fmt.Errorf("token file %s is empty", s.path)
+	}
+	s.token = Token{Value: value, Expiry: jwtExpiry(value)}
+	s.modTime = info.ModTime()
+	return s.token, nil
+}
+
+// Invalidate forces the file to be read again
+func (s *FileTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = Token{}
+}
+
+// OAuth2ClientCredentials fetches tokens with the OAuth2 client credentials grant
+// (RFC 6749 section 4.4) and caches them until shortly before they expire
+type OAuth2ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// HTTPClient is used for token requests; nil uses a client with a 30 second timeout
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	token Token
+}
+
+// Token returns the cached token, or requests a new one when it is about to expire
+func (s *OAuth2ClientCredentials) Token(ctx context.Context) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Value != "" && (s.token.Expiry.IsZero() || time.Until(s.token.Expiry) > tokenRefreshMargin) {
+		return s.token, nil
+	}
+	token, err := s.fetch(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// Invalidate drops the cached token
+func (s *OAuth2ClientCredentials) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = Token{}
+}
+
+// fetch performs the token request
+func (s *OAuth2ClientCredentials) fetch(ctx context.Context) (Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, fmt.Errorf("oauth2: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+
+	client := s.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("oauth2: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Token{}, fmt.Errorf("oauth2: failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Token{}, fmt.Errorf("oauth2: token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Token{}, fmt.Errorf("oauth2: invalid token response: %w", err)
+	}
+	if payload.AccessToken == "" {
+		return Token{}, errors.New("oauth2: token response has no access_token")
+	}
+	token := Token{Value: payload.AccessToken, Expiry: jwtExpiry(payload.AccessToken)}
+	if payload.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// jwtExpiry returns the exp claim of a JWT, or the zero time for other tokens.
+// The signature is not verified; the expiry is only used to refresh in time.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var payload struct {
+		Exp float64 `json:"exp"`
+	}
+	if json.Unmarshal(claims, &payload) != nil || payload.Exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(payload.Exp), 0)
+}
@@ -0,0 +1,229 @@
+package communication
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/workflowsgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestOAuth2ClientCredentialsCachesUntilInvalidated(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || id != "worker" || secret != "s3cret" || r.FormValue("scope") != "events" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		requests++
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, requests)
+	}))
+	defer server.Close()
+
+	source := &OAuth2ClientCredentials{TokenURL: server.URL, ClientID: "worker", ClientSecret: "s3cret", Scopes: []string{"events"}}
+	first, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := source.Token(context.Background())
+	if first.Value != "token-1" || second.Value != "token-1" || time.Until(first.Expiry) < 59*time.Minute {
+		t.Fatalf("expected the cached token, got %+v and %+v", first, second)
+	}
+
+	source.Invalidate()
+	third, _ := source.Token(context.Background())
+	if third.Value != "token-2" {
+		t.Fatalf("expected a new token after Invalidate, got %q", third.Value)
+	}
+}
+
+func TestFileTokenSourceReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	exp := time.Now().Add(time.Hour).Unix()
+	jwt := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp))) + ".sig"
+	writeFile(t, path, []byte(jwt+"\n"))
+
+	source := NewFileTokenSource(path)
+	token, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Value != jwt || token.Expiry.Unix() != exp {
+		t.Fatalf("unexpected token %+v", token)
+	}
+
+	writeFile(t, path, []byte("rotated"))
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if token, _ = source.Token(context.Background()); token.Value != "rotated" || !token.Expiry.IsZero() {
+		t.Fatalf("expected the rotated token, got %+v", token)
+	}
+}
+
+// authServer accepts streams carrying one of the valid tokens and reports which token
+// each registration used
+type authServer struct {
+	workflowsgrpc.UnimplementedEventServiceServer
+	valid      map[string]bool
+	registered chan string
+}
+
+func (s *authServer) Events(stream grpc.BidiStreamingServer[workflowsgrpc.GrpcEventMessage, workflowsgrpc.GrpcEventMessage]) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	auth := md.Get("authorization")
+	if len(auth) != 1 || !s.valid[strings.TrimPrefix(auth[0], "Bearer ")] {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		if msg.Event == types.EventClientRegistration {
+			s.registered <- strings.TrimPrefix(auth[0], "Bearer ")
+		}
+	}
+}
+
+// sequenceTokenSource hands out its tokens in order, moving on when invalidated or
+// after handing out a token that expires
+type sequenceTokenSource struct {
+	mu     sync.Mutex
+	tokens []Token
+	next   int
+}
+
+func (s *sequenceTokenSource) Token(context.Context) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := s.tokens[s.next]
+	if !token.Expiry.IsZero() && s.next < len(s.tokens)-1 {
+		s.next++
+	}
+	return token, nil
+}
+
+func (s *sequenceTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next < len(s.tokens)-1 {
+		s.next++
+	}
+}
+
+func startAuthServer(t *testing.T, server *authServer) string {
+	t.Helper()
+	grpcServer := grpc.NewServer()
+	workflowsgrpc.RegisterEventServiceServer(grpcServer, server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String()
+}
+
+func waitForRegistration(t *testing.T, server *authServer, token string) {
+	t.Helper()
+	select {
+	case used := <-server.registered:
+		if used != token {
+			t.Fatalf("expected a registration with %q, got %q", token, used)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no registration with %q", token)
+	}
+}
+
+func TestGrpcCommunicatorRetriesImmediatelyAfterUnauthenticated(t *testing.T) {
+	server := &authServer{valid: map[string]bool{"fresh": true}, registered: make(chan string, 1)}
+	addr := startAuthServer(t, server)
+
+	// A 60 second reconnect interval would time the test out if the retry waited for it
+	gc := NewGrpcCommunicatorWithOptions(addr, "auth-worker", "", 10, 60, 60)
+	gc.SetTokenSource(&sequenceTokenSource{tokens: []Token{{Value: "stale"}, {Value: "fresh"}}})
+	gc.Connect()
+	defer gc.Close()
+
+	waitForRegistration(t, server, "fresh")
+}
+
+func TestGrpcCommunicatorRefreshesStreamBeforeTokenExpires(t *testing.T) {
+	server := &authServer{valid: map[string]bool{"first": true, "second": true}, registered: make(chan string, 2)}
+	addr := startAuthServer(t, server)
+
+	source := &sequenceTokenSource{tokens: []Token{
+		{Value: "first", Expiry: time.Now().Add(2 * time.Second)},
+		{Value: "second"},
+	}}
+	gc := NewGrpcCommunicatorWithOptions(addr, "auth-worker", "", 10, 60, 60)
+	gc.SetTokenSource(source)
+	gc.Connect()
+	defer gc.Close()
+
+	waitForRegistration(t, server, "first")
+	// The stream is re-established with the next token well before the first one expires
+	waitForRegistration(t, server, "second")
+	if !gc.IsConnected() {
+		t.Fatal("worker disconnected while refreshing its stream")
+	}
+}
+
+func TestGrpcCommunicatorRefusesExpiredStaticToken(t *testing.T) {
+	// The server would accept the token, the worker knows better
+	jwt := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(-time.Hour).Unix()))) + ".sig"
+	server := &authServer{valid: map[string]bool{jwt: true}, registered: make(chan string, 100)}
+	addr := startAuthServer(t, server)
+	gc := NewGrpcCommunicatorWithOptions(addr, "auth-worker", jwt, 10, 60, 60)
+	gc.Connect()
+	defer gc.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for gc.State() != StateAuthFailed {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s, got %s", StateAuthFailed, gc.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := len(server.registered); n != 0 {
+		t.Fatalf("registered %d times with an expired token", n)
+	}
+}
+
+func TestGrpcCommunicatorKeepsStreamWithoutNewerToken(t *testing.T) {
+	server := &authServer{valid: map[string]bool{"only": true}, registered: make(chan string, 100)}
+	addr := startAuthServer(t, server)
+
+	// The source keeps handing out the same token as it runs out
+	source := &sequenceTokenSource{tokens: []Token{{Value: "only", Expiry: time.Now().Add(1500 * time.Millisecond)}}}
+	gc := NewGrpcCommunicatorWithOptions(addr, "auth-worker", "", 10, 60, 60)
+	gc.SetTokenSource(source)
+	gc.Connect()
+	defer gc.Close()
+
+	waitForRegistration(t, server, "only")
+	time.Sleep(1500 * time.Millisecond)
+	if n := len(server.registered); n != 0 {
+		t.Fatalf("re-established the stream %d times with the same token", n)
+	}
+	if !gc.IsConnected() {
+		t.Fatal("worker disconnected")
+	}
+}
@@ -0,0 +1,337 @@
+package correlation
+
+import (
+	"context"
+	"errors"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/utils"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrExpired is returned by Await when the entry's deadline passes before a response arrives.
+	ErrExpired = errors.New("correlation: deadline passed before a response arrived")
+
+	// ErrDuplicateID is returned by Register when the ID is pending or finished recently.
+	ErrDuplicateID = errors.New("correlation: ID is already in use")
+)
+
+// recentLimit bounds how many finished IDs are remembered to tell late and duplicate
+// responses apart from orphans.
+const recentLimit = 4096
+
+// Pending describes a request waiting for its response.
+type Pending struct {
+	ID       string    `json:"correlation_id"`
+	Kind     string    `json:"kind"` // the request event, e.g. store_get_request
+	Since    time.Time `json:"since"`
+	Deadline time.Time `json:"deadline,omitempty"` // zero when the entry never expires
+}
+
+// Stats counts how responses were matched against pending requests.
+type Stats struct {
+	Pending   int    // requests waiting for a response
+	Delivered uint64 // responses handed to their waiting caller
+	Expired   uint64 // requests whose deadline passed without a response
+	Orphaned  uint64 // responses for IDs that were never registered
+	Late      uint64 // responses arriving after the caller gave up
+	Duplicate uint64 // further responses for an already answered ID
+	Collision uint64 // generated IDs refused because they were already in use
+}
+
+type entry struct {
+	pending   Pending
+	ch        chan types.EventMessage
+	timer     *time.Timer
+	delivered bool
+	discard   bool                      // the response is acknowledged and dropped
+	updates   chan<- types.EventMessage // receives intermediate messages when subscribed
+}
+
+// Registry matches responses to the requests waiting for them by correlation ID. It is
+// shared by every client of the workflow stream, never blocks on delivery and keeps
+// recently finished IDs to classify stray responses.
+type Registry struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	// recent maps finished IDs to whether they were answered; recentIDs evicts them in order
+	recent    map[string]bool
+	recentIDs []string
+	next      int
+
+	delivered  atomic.Uint64
+	expired    atomic.Uint64
+	orphaned   atomic.Uint64
+	late       atomic.Uint64
+	duplicate  atomic.Uint64
+	collisions atomic.Uint64
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		entries:   make(map[string]*entry),
+		recent:    make(map[string]bool),
+		recentIDs: make([]string, recentLimit),
+	}
+}
+
+// Register adds a pending request and returns the channel its response is delivered on.
+// kind names the request for Pending. After a non-zero deadline the entry is removed and
+// the channel closed, which Await reports as ErrExpired. Callers must Remove the ID once
+// they stop waiting. An ID that is already pending, or was answered recently, is refused
+// with ErrDuplicateID so that two callers never share a response.
+func (r *Registry) Register(id, kind string, deadline time.Time) (<-chan types.EventMessage, error) {
+	e := &entry{
+		pending: Pending{ID: id, Kind: kind, Since: time.Now(), Deadline: deadline},
+		ch:      make(chan types.EventMessage, 1),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, pending := r.entries[id]; pending {
+		return nil, ErrDuplicateID
+	}
+	if _, finished := r.recent[id]; finished {
+		return nil, ErrDuplicateID
+	}
+	r.entries[id] = e
+	if !deadline.IsZero() {
+		e.timer = time.AfterFunc(time.Until(deadline), func() { r.expire(id, e) })
+	}
+	return e.ch, nil
+}
+
+// Reserve registers a pending request under a new ID and returns the ID with the
+// channel its response is delivered on. A colliding ID is logged and replaced.
+func (r *Registry) Reserve(kind string, deadline time.Time) (string, <-chan types.EventMessage) {
+	for {
+		id := utils.UID()
+		ch, err := r.Register(id, kind, deadline)
+		if err == nil {
+			return id, ch
+		}
+		r.collisions.Add(1)
+		log.Printf("Correlation: ID %s collided with another request, generating a new one", id)
+	}
+}
+
+// Expect registers a request nobody waits on under a new ID, such as a set whose
+// acknowledgement is optional. Its response is counted as delivered and dropped instead
+// of being reported as orphaned. Without one the entry quietly finishes at deadline, and
+// it is never listed as pending.
+func (r *Registry) Expect(kind string, deadline time.Time) string {
+	id, _ := r.Reserve(kind, deadline)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[id]; ok {
+		e.discard = true
+	}
+	return id
+}
+
+// Remove deletes the entry for id. A response arriving afterwards counts as late, or as
+// a duplicate if the entry had already been answered.
+func (r *Registry) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[id]; ok {
+		r.finish(id, e)
+	}
+}
+
+// expire removes an entry whose deadline passed and wakes its caller.
+func (r *Registry) expire(id string, e *entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries[id] != e || e.delivered {
+		return
+	}
+	r.finish(id, e)
+	close(e.ch)
+	if e.discard {
+		return
+	}
+	r.expired.Add(1)
+	log.Printf("Correlation: %s %s expired without a response after %v", e.pending.Kind, id, time.Since(e.pending.Since).Round(time.Millisecond))
+}
+
+// finish moves an entry to the recently finished IDs. Callers hold r.mu.
+func (r *Registry) finish(id string, e *entry) {
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	delete(r.entries, id)
+	if evicted := r.recentIDs[r.next]; evicted != "" {
+		delete(r.recent, evicted)
+	}
+	r.recentIDs[r.next] = id
+	r.next = (r.next + 1) % recentLimit
+	r.recent[id] = e.delivered
+}
+
+// Deliver hands msg to the request with its correlation ID and reports whether it was
+// waiting for one. It never blocks; orphaned, late and duplicate responses are counted,
+// logged and dropped.
+func (r *Registry) Deliver(msg types.EventMessage) bool {
+	id := msg.CorrelationID
+	r.mu.Lock()
+	e, ok := r.entries[id]
+	if ok && !e.delivered {
+		e.delivered = true
+		if e.discard {
+			r.finish(id, e)
+			if msg.Event == types.EventError {
+				log.Printf("Correlation: %s %s failed: %v", e.pending.Kind, id, types.ErrorFromEvent(&msg))
+			}
+		} else {
+			e.ch <- msg // buffered for exactly this one response
+		}
+		r.mu.Unlock()
+		r.delivered.Add(1)
+		return true
+	}
+	answered, finished := r.recent[id]
+	r.mu.Unlock()
+
+	switch {
+	case ok || (finished && answered):
+		r.duplicate.Add(1)
+		log.Printf("Correlation: dropping duplicate %s (correlation ID: %s)", msg.Event, id)
+	case finished:
+		r.late.Add(1)
+		log.Printf("Correlation: dropping late %s, the caller stopped waiting (correlation ID: %s)", msg.Event, id)
+	default:
+		r.orphaned.Add(1)
+		log.Printf("Correlation: dropping orphaned %s, no request is waiting for it (correlation ID: %s)", msg.Event, id)
+	}
+	return false
+}
+
+// Subscribe forwards the intermediate messages Notify receives for the pending request
+// id to updates until its response arrives, and reports whether id was pending. Updates
+// that do not fit in the buffer of updates are logged and dropped.
+func (r *Registry) Subscribe(id string, updates chan<- types.EventMessage) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[id]
+	if !ok || e.delivered {
+		return false
+	}
+	e.updates = updates
+	return true
+}
+
+// Notify hands an intermediate message, such as a status update, to the subscriber of
+// the request with its correlation ID and reports whether one was waiting for it. It
+// never blocks.
+func (r *Registry) Notify(msg types.EventMessage) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[msg.CorrelationID]
+	if !ok || e.delivered || e.updates == nil {
+		return false
+	}
+	select {
+	case e.updates <- msg:
+	default:
+		log.Printf("Correlation: dropping %s for %s %s, the subscriber is not keeping up", msg.Event, e.pending.Kind, msg.CorrelationID)
+	}
+	return true
+}
+
+// Await waits for the response on ch. It returns ctx.Err() when ctx ends first and
+// ErrExpired when the entry's deadline passes first.
+func (r *Registry) Await(ctx context.Context, ch <-chan types.EventMessage) (types.EventMessage, error) {
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return types.EventMessage{}, ErrExpired
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return types.EventMessage{}, ctx.Err()
+	}
+}
+
+// AwaitUpdates waits for the response on ch like Await and calls fn with every message
+// forwarded to updates in the meantime. Updates that arrived before the response are
+// passed to fn before AwaitUpdates returns.
+func (r *Registry) AwaitUpdates(ctx context.Context, ch, updates <-chan types.EventMessage, fn func(types.EventMessage)) (types.EventMessage, error) {
+	for {
+		select {
+		case update := <-updates:
+			fn(update)
+		case msg, ok := <-ch:
+			drain(updates, fn)
+			if !ok {
+				return types.EventMessage{}, ErrExpired
+			}
+			return msg, nil
+		case <-ctx.Done():
+			return types.EventMessage{}, ctx.Err()
+		}
+	}
+}
+
+// drain passes the messages already buffered in updates to fn
+func drain(updates <-chan types.EventMessage, fn func(types.EventMessage)) {
+	for {
+		select {
+		case update := <-updates:
+			fn(update)
+		default:
+			return
+		}
+	}
+}
+
+// Pending lists the requests waiting for a response, oldest first.
+func (r *Registry) Pending() []Pending {
+	r.mu.Lock()
+	pending := make([]Pending, 0, len(r.entries))
+	for _, e := range r.entries {
+		if !e.delivered && !e.discard {
+			pending = append(pending, e.pending)
+		}
+	}
+	r.mu.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Since.Before(pending[j].Since) })
+	return pending
+}
+
+// Stats returns the number of pending requests and the response counters.
+func (r *Registry) Stats() Stats {
+	r.mu.Lock()
+	pending := 0
+	for _, e := range r.entries {
+		if !e.delivered && !e.discard {
+			pending++
+		}
+	}
+	r.mu.Unlock()
+	return Stats{
+		Pending:   pending,
+		Delivered: r.delivered.Load(),
+		Expired:   r.expired.Load(),
+		Orphaned:  r.orphaned.Load(),
+		Late:      r.late.Load(),
+		Duplicate: r.duplicate.Load(),
+		Collision: r.collisions.Load(),
+	}
+}
+
+// DeadlineOf returns the deadline of ctx, or now plus fallback when ctx has none.
+// A fallback of 0 means no deadline.
+func DeadlineOf(ctx context.Context, fallback time.Duration) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	if fallback <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(fallback)
+}
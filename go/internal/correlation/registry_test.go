@@ -0,0 +1,80 @@
+package correlation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+func TestRegistryClassifiesStrayResponses(t *testing.T) {
+	r := NewRegistry()
+	ch, _ := r.Register("answered", types.EventStoreGetRequest, time.Time{})
+	r.Register("abandoned", types.EventCacheGetRequest, time.Time{})
+	if _, err := r.Register("answered", types.EventStoreGetRequest, time.Time{}); !errors.Is(err, ErrDuplicateID) {
+		t.Fatalf("expected ErrDuplicateID, got %v", err)
+	}
+	if pending := r.Pending(); len(pending) != 2 || pending[0].ID != "answered" || pending[1].Kind != types.EventCacheGetRequest {
+		t.Fatalf("unexpected pending list %+v", pending)
+	}
+
+	response := types.EventMessage{Event: types.EventStoreGetResponse, CorrelationID: "answered"}
+	if !r.Deliver(response) {
+		t.Fatal("expected the response to be delivered")
+	}
+	// A duplicate must neither block nor replace the first response
+	if r.Deliver(response) {
+		t.Fatal("expected the duplicate to be dropped")
+	}
+	if msg, err := r.Await(context.Background(), ch); err != nil || msg.Event != types.EventStoreGetResponse {
+		t.Fatalf("unexpected response %+v, %v", msg, err)
+	}
+	r.Remove("answered")
+	r.Remove("abandoned")
+
+	r.Deliver(response)
+	r.Deliver(types.EventMessage{Event: types.EventCacheGetResponse, CorrelationID: "abandoned"})
+	r.Deliver(types.EventMessage{Event: types.EventCacheGetResponse, CorrelationID: "unknown"})
+
+	stats := r.Stats()
+	if stats.Pending != 0 || stats.Delivered != 1 || stats.Duplicate != 2 || stats.Late != 1 || stats.Orphaned != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRegistryExpiresEntriesAtTheirDeadline(t *testing.T) {
+	r := NewRegistry()
+	id, ch := r.Reserve(types.EventFunctionRequest, time.Now().Add(20*time.Millisecond))
+	if _, err := r.Await(context.Background(), ch); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+	r.Remove(id)
+
+	r.Deliver(types.EventMessage{Event: types.EventFunctionResponse, CorrelationID: id})
+	if stats := r.Stats(); stats.Expired != 1 || stats.Late != 1 || stats.Pending != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRegistryDropsExpectedResponses(t *testing.T) {
+	r := NewRegistry()
+	acked := r.Expect(types.EventCacheSet, time.Now().Add(time.Minute))
+	unacked := r.Expect(types.EventStoreSetRequest, time.Now().Add(20*time.Millisecond))
+	if pending := r.Pending(); len(pending) != 0 {
+		t.Fatalf("expected nothing to be pending, got %+v", pending)
+	}
+
+	if !r.Deliver(types.EventMessage{Event: types.EventCacheSetResponse, CorrelationID: acked}) {
+		t.Fatal("expected the acknowledgement to be accepted")
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Deliver(types.EventMessage{Event: types.EventCacheSetResponse, CorrelationID: acked})
+	r.Deliver(types.EventMessage{Event: types.EventStoreSetResponse, CorrelationID: unacked})
+
+	stats := r.Stats()
+	if stats.Delivered != 1 || stats.Orphaned != 0 || stats.Expired != 0 || stats.Duplicate != 1 || stats.Late != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
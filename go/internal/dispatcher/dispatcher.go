@@ -1,57 +1,400 @@
 package dispatcher
 
 import (
+	"context"
+	"errors"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
 	"log"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrStopped is returned by Dispatch once the dispatcher has stopped accepting jobs.
+	ErrStopped = errors.New("dispatcher is stopped")
+
+	// ErrOverloaded is returned by TryDispatch when the queue is full.
+	ErrOverloaded = errors.New("dispatcher queue is full")
 )
 
 // Handler processes a single event message.
 type Handler func(*types.EventMessage)
 
-// Dispatcher routes events to registered handlers and executes them via a worker pool.
+// PanicHandler is notified when a handler panics while processing msg.
+type PanicHandler func(msg *types.EventMessage, recovered any, stack []byte)
+
+// LimitFunc returns the concurrency key of an execution and the number of executions
+// with that key allowed to run at once. A limit of 0 means unlimited.
+type LimitFunc func(msg *types.EventMessage) (key string, limit int)
+
+// Lane selects the worker pool that processes an event.
+type Lane int
+
+const (
+	// LaneExecution runs events on the pool started by Start, subject to the
+	// concurrency limits returned by the LimitFunc.
+	LaneExecution Lane = iota
+	// LaneControl runs events on a separate pool so responses and control events are
+	// never queued behind executions, which may themselves be waiting for them.
+	LaneControl
+)
+
+// ControlWorkers is the number of workers serving LaneControl.
+const ControlWorkers = 4
+
+type route struct {
+	handler Handler
+	lane    Lane
+}
+
+// parkedJob is an execution waiting for a concurrency slot: either a queued message or
+// a caller of Acquire, woken by closing wake
+type parkedJob struct {
+	msg  *types.EventMessage
+	wake chan struct{}
+}
+
+// Dispatcher routes events to registered handlers and executes them via worker pools.
 type Dispatcher struct {
-	registry map[string]Handler
+	registry map[string]route
 	jobs     chan *types.EventMessage
+	control  chan *types.EventMessage
 	wg       sync.WaitGroup
+	onPanic  PanicHandler
+	limitOf  LimitFunc
+	started  sync.Once
+
+	// slotsMu guards the running count and the executions parked per concurrency key
+	slotsMu sync.Mutex
+	running map[string]int
+	parked  map[string][]parkedJob
+
+	// mu guards stopped. Senders register in senders under it, and the queues are only
+	// closed once they have left, so Dispatch never sends on a closed channel; quit ends
+	// the wait of senders blocked on a full queue.
+	mu      sync.RWMutex
+	stopped bool
+	senders sync.WaitGroup
+	quit    chan struct{}
+	closed  sync.Once
+
+	workers  atomic.Int64
+	inFlight atomic.Int64
+	waiting  atomic.Int64
+	rejected atomic.Uint64
+}
+
+// Stats is a snapshot of the dispatcher's load.
+type Stats struct {
+	Workers       int    // execution workers started
+	Queued        int    // executions waiting for a worker or a concurrency slot
+	Capacity      int    // size of the execution queue
+	InFlight      int    // executions being handled
+	Rejected      uint64 // executions refused by TryDispatch because the queue was full
+	ControlQueued int    // control events waiting for a control worker
 }
 
-// NewDispatcher creates a dispatcher with a bounded queue of the given size.
+// NewDispatcher creates a dispatcher whose lanes each have a bounded queue of the given size.
 func NewDispatcher(queueSize int) *Dispatcher {
 	return &Dispatcher{
-		registry: make(map[string]Handler),
+		registry: make(map[string]route),
 		jobs:     make(chan *types.EventMessage, queueSize),
+		control:  make(chan *types.EventMessage, queueSize),
+		running:  make(map[string]int),
+		parked:   make(map[string][]parkedJob),
+		quit:     make(chan struct{}),
 	}
 }
 
-// Register associates an event name with a handler.
-func (d *Dispatcher) Register(event string, handler Handler) { d.registry[event] = handler }
+// Register associates an event name with a handler running on the execution lane.
+func (d *Dispatcher) Register(event string, handler Handler) {
+	d.registry[event] = route{handler: handler, lane: LaneExecution}
+}
+
+// RegisterControl associates an event name with a handler running on the control lane.
+// Control handlers must be short; they are shared by all responses and control events.
+func (d *Dispatcher) RegisterControl(event string, handler Handler) {
+	d.registry[event] = route{handler: handler, lane: LaneControl}
+}
+
+// SetLimitFunc installs the per-key concurrency limits of the execution lane. An
+// execution over its limit is parked without holding a worker and runs as soon as
+// an execution with the same key finishes.
+func (d *Dispatcher) SetLimitFunc(limitOf LimitFunc) { d.limitOf = limitOf }
 
-// Start launches n worker goroutines to process queued events.
+// SetPanicHandler installs a callback for handler panics. Panics are always
+// recovered so that one bad message cannot take down the worker process.
+func (d *Dispatcher) SetPanicHandler(handler PanicHandler) { d.onPanic = handler }
+
+// Start launches n execution workers. The first call also starts the control workers.
 func (d *Dispatcher) Start(n int) {
 	if n <= 0 {
 		n = 1
 	}
+	d.started.Do(func() {
+		d.wg.Add(ControlWorkers)
+		for i := 0; i < ControlWorkers; i++ {
+			go func() {
+				defer d.wg.Done()
+				for msg := range d.control {
+					d.Run(msg)
+				}
+			}()
+		}
+	})
 	d.wg.Add(n)
+	d.workers.Add(int64(n))
 	for i := 0; i < n; i++ {
 		go func() {
 			defer d.wg.Done()
 			for msg := range d.jobs {
-				if handler, ok := d.registry[msg.Event]; ok {
-					handler(msg)
-				} else {
-					log.Printf("No handler registered for event: %s", msg.Event)
+				key, ok := d.acquire(msg)
+				if !ok {
+					continue
 				}
+				d.runHolding(key, msg)
 			}
 		}()
 	}
 }
 
+// runHolding runs msg with the slot held for key, keeping the slot to run the
+// executions parked behind it
+func (d *Dispatcher) runHolding(key string, msg *types.EventMessage) {
+	for msg != nil {
+		d.inFlight.Add(1)
+		d.Run(msg)
+		d.inFlight.Add(-1)
+		msg = d.release(key)
+	}
+}
+
+// acquire takes a concurrency slot for msg. It returns false after parking msg
+// because its key is at the limit.
+func (d *Dispatcher) acquire(msg *types.EventMessage) (string, bool) {
+	if d.limitOf == nil {
+		return "", true
+	}
+	key, limit := d.limitOf(msg)
+	if limit <= 0 {
+		return "", true
+	}
+	d.slotsMu.Lock()
+	defer d.slotsMu.Unlock()
+	if d.running[key] >= limit {
+		d.parked[key] = append(d.parked[key], parkedJob{msg: msg})
+		d.waiting.Add(1)
+		return "", false
+	}
+	d.running[key]++
+	return key, true
+}
+
+// release frees the slot held for key, or hands it to the next parked execution.
+// A parked message is returned for the caller to run; a parked Acquire is woken.
+func (d *Dispatcher) release(key string) *types.EventMessage {
+	if key == "" {
+		return nil
+	}
+	d.slotsMu.Lock()
+	defer d.slotsMu.Unlock()
+	if queue := d.parked[key]; len(queue) > 0 {
+		next := queue[0]
+		queue[0] = parkedJob{}
+		if len(queue) == 1 {
+			delete(d.parked, key)
+		} else {
+			d.parked[key] = queue[1:]
+		}
+		if next.wake != nil {
+			close(next.wake)
+			return nil
+		}
+		d.waiting.Add(-1)
+		return next.msg
+	}
+	if d.running[key]--; d.running[key] <= 0 {
+		delete(d.running, key)
+	}
+	return nil
+}
+
+// Acquire takes a concurrency slot for an execution of msg that runs outside the
+// execution lane, such as an in-process tool call, waiting behind the executions
+// already parked at the limit. It returns the function releasing the slot, or the
+// cause of ctx when ctx ends first. Callers waiting here do not count as queued.
+func (d *Dispatcher) Acquire(ctx context.Context, msg *types.EventMessage) (release func(), err error) {
+	if d.limitOf == nil {
+		return func() {}, nil
+	}
+	key, limit := d.limitOf(msg)
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	release = func() {
+		// Executions parked behind this one no longer have a worker waiting for them
+		if next := d.release(key); next != nil {
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				d.runHolding(key, next)
+			}()
+		}
+	}
+
+	d.slotsMu.Lock()
+	if d.running[key] < limit {
+		d.running[key]++
+		d.slotsMu.Unlock()
+		return release, nil
+	}
+	wake := make(chan struct{})
+	d.parked[key] = append(d.parked[key], parkedJob{wake: wake})
+	d.slotsMu.Unlock()
+
+	select {
+	case <-wake:
+		return release, nil
+	case <-ctx.Done():
+	}
+	d.slotsMu.Lock()
+	queue := d.parked[key]
+	handedOver := true
+	for i, job := range queue {
+		if job.wake == wake {
+			d.parked[key] = append(queue[:i:i], queue[i+1:]...)
+			if len(d.parked[key]) == 0 {
+				delete(d.parked, key)
+			}
+			handedOver = false
+			break
+		}
+	}
+	d.slotsMu.Unlock()
+	// The slot was handed over while ctx ended
+	if handedOver {
+		release()
+	}
+	return nil, context.Cause(ctx)
+}
+
+// Run executes the registered handler for msg synchronously on the calling goroutine.
+// A panicking handler is recovered and reported to the panic handler.
+func (d *Dispatcher) Run(msg *types.EventMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			log.Printf("Recovered panic in handler for event %s (correlation ID: %s): %v\n%s", msg.Event, msg.CorrelationID, r, stack)
+			if d.onPanic != nil {
+				d.onPanic(msg, r, stack)
+			}
+		}
+	}()
+
+	if route, ok := d.registry[msg.Event]; ok {
+		route.handler(msg)
+	} else {
+		log.Printf("No handler registered for event: %s", msg.Event)
+	}
+}
+
 // Stop stops accepting new jobs and waits for workers to finish.
 func (d *Dispatcher) Stop() {
-	close(d.jobs)
-	d.wg.Wait()
+	_ = d.Shutdown(context.Background())
 }
 
-// Dispatch enqueues a message for processing.
-func (d *Dispatcher) Dispatch(msg *types.EventMessage) { d.jobs <- msg }
+// Shutdown stops accepting new jobs and waits for queued and running jobs to finish.
+// Dispatch calls waiting for room in a full queue return ErrStopped. It returns
+// ctx.Err() if the context ends before the workers are done; the workers keep running
+// in the background in that case.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.stopped {
+		d.stopped = true
+		close(d.quit)
+	}
+	d.mu.Unlock()
+	d.senders.Wait()
+	d.closed.Do(func() {
+		close(d.jobs)
+		close(d.control)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queueFor returns the queue of the lane msg is registered on. Unregistered events
+// go to the execution lane, where Run reports them.
+func (d *Dispatcher) queueFor(msg *types.EventMessage) chan *types.EventMessage {
+	if d.registry[msg.Event].lane == LaneControl {
+		return d.control
+	}
+	return d.jobs
+}
+
+// Dispatch enqueues a message on its lane, waiting while that lane's queue is full.
+// It returns ErrStopped after Shutdown, including when Shutdown starts while it waits.
+func (d *Dispatcher) Dispatch(msg *types.EventMessage) error {
+	d.mu.RLock()
+	if d.stopped {
+		d.mu.RUnlock()
+		return ErrStopped
+	}
+	d.senders.Add(1)
+	d.mu.RUnlock()
+	defer d.senders.Done()
+
+	select {
+	case d.queueFor(msg) <- msg:
+		return nil
+	case <-d.quit:
+		return ErrStopped
+	}
+}
+
+// TryDispatch enqueues a message without waiting. It returns ErrOverloaded when the
+// lane's queue is full, counting executions parked at their concurrency limit, so the
+// caller can refuse the work instead of stalling, and ErrStopped after Shutdown.
+func (d *Dispatcher) TryDispatch(msg *types.EventMessage) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.stopped {
+		return ErrStopped
+	}
+	queue := d.queueFor(msg)
+	if queue == d.jobs && len(d.jobs)+int(d.waiting.Load()) >= cap(d.jobs) {
+		d.rejected.Add(1)
+		return ErrOverloaded
+	}
+	select {
+	case queue <- msg:
+		return nil
+	default:
+		d.rejected.Add(1)
+		return ErrOverloaded
+	}
+}
+
+// Stats returns the current queue depths, in-flight count and rejection counter.
+func (d *Dispatcher) Stats() Stats {
+	return Stats{
+		Workers:       int(d.workers.Load()),
+		Queued:        len(d.jobs) + int(d.waiting.Load()),
+		Capacity:      cap(d.jobs),
+		InFlight:      int(d.inFlight.Load()),
+		Rejected:      d.rejected.Load(),
+		ControlQueued: len(d.control),
+	}
+}
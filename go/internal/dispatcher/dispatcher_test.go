@@ -0,0 +1,194 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+func TestTryDispatchRejectsWhenQueueIsFull(t *testing.T) {
+	d := NewDispatcher(1)
+	if err := d.TryDispatch(&types.EventMessage{Event: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.TryDispatch(&types.EventMessage{Event: "second"}); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected ErrOverloaded, got %v", err)
+	}
+	if stats := d.Stats(); stats.Queued != 1 || stats.Capacity != 1 || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	handled := make(chan string, 1)
+	d.Register("first", func(msg *types.EventMessage) { handled <- msg.Event })
+	d.Start(1)
+	if event := <-handled; event != "first" {
+		t.Fatalf("unexpected event %q", event)
+	}
+	d.Stop()
+	if err := d.TryDispatch(&types.EventMessage{Event: "late"}); !errors.Is(err, ErrStopped) {
+		t.Fatalf("expected ErrStopped, got %v", err)
+	}
+}
+
+func TestConcurrencyLimitParksExecutionsWithoutBlockingWorkers(t *testing.T) {
+	d := NewDispatcher(10)
+	d.SetLimitFunc(func(msg *types.EventMessage) (string, int) {
+		if msg.Function == "slow" {
+			return "slow", 1
+		}
+		return msg.Function, 0
+	})
+	release := make(chan struct{})
+	started := make(chan string, 10)
+	d.Register(types.EventFunctionRequest, func(msg *types.EventMessage) {
+		started <- msg.Function + msg.CorrelationID
+		if msg.Function == "slow" {
+			<-release
+		}
+	})
+	d.RegisterControl(types.EventCacheGetResponse, func(msg *types.EventMessage) {
+		started <- msg.Event
+	})
+	d.Start(2)
+	defer d.Stop()
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-started:
+			if got != want {
+				t.Fatalf("expected %s to run, got %s", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not run", want)
+		}
+	}
+
+	d.Dispatch(&types.EventMessage{Event: types.EventFunctionRequest, Function: "slow", CorrelationID: "1"})
+	expect("slow1")
+	d.Dispatch(&types.EventMessage{Event: types.EventFunctionRequest, Function: "slow", CorrelationID: "2"})
+	d.Dispatch(&types.EventMessage{Event: types.EventFunctionRequest, Function: "fast", CorrelationID: "3"})
+	// The second slow execution is parked, so the other worker is free for fast ones
+	expect("fast3")
+	if stats := d.Stats(); stats.Queued != 1 || stats.InFlight != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// Responses run on the control lane, whatever the executions are doing
+	d.Dispatch(&types.EventMessage{Event: types.EventCacheGetResponse})
+	expect(types.EventCacheGetResponse)
+
+	release <- struct{}{}
+	expect("slow2")
+	release <- struct{}{}
+}
+
+func TestAcquireSharesConcurrencySlotsWithQueuedExecutions(t *testing.T) {
+	d := NewDispatcher(10)
+	d.SetLimitFunc(func(msg *types.EventMessage) (string, int) { return msg.Function, 1 })
+	ran := make(chan string, 10)
+	d.Register(types.EventFunctionRequest, func(msg *types.EventMessage) { ran <- msg.CorrelationID })
+	d.Start(1)
+	defer d.Stop()
+
+	msg := &types.EventMessage{Event: types.EventFunctionRequest, Function: "limited"}
+	release, err := d.Acquire(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Dispatch(&types.EventMessage{Event: types.EventFunctionRequest, Function: "limited", CorrelationID: "queued"})
+	parked := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			d.slotsMu.Lock()
+			got := len(d.parked["limited"])
+			d.slotsMu.Unlock()
+			if got == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d parked executions, got %d", n, got)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	parked(1)
+
+	// A caller whose context ends gives up its place in line
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := d.Acquire(ctx, msg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to end the wait, got %v", err)
+	}
+	acquired := make(chan func(), 1)
+	go func() {
+		next, _ := d.Acquire(context.Background(), msg)
+		acquired <- next
+	}()
+	parked(2)
+	if stats := d.Stats(); stats.Queued != 1 {
+		t.Fatalf("callers of Acquire must not count as queued, got %+v", stats)
+	}
+
+	// Releasing runs the queued execution first, then hands the slot to the caller
+	release()
+	select {
+	case id := <-ran:
+		if id != "queued" {
+			t.Fatalf("unexpected execution %q", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued execution did not run")
+	}
+	select {
+	case next := <-acquired:
+		next()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiting caller did not get the slot")
+	}
+	d.slotsMu.Lock()
+	defer d.slotsMu.Unlock()
+	if len(d.running) != 0 || len(d.parked) != 0 {
+		t.Fatalf("slots leaked: running %v, parked %v", d.running, d.parked)
+	}
+}
+
+func TestShutdownEndsDispatchWaitingForAFullQueue(t *testing.T) {
+	d := NewDispatcher(1)
+	release := make(chan struct{})
+	d.Register("slow", func(*types.EventMessage) { <-release })
+	d.Start(1)
+
+	if err := d.Dispatch(&types.EventMessage{Event: "slow"}); err != nil {
+		t.Fatal(err)
+	}
+	for d.Stats().InFlight != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := d.Dispatch(&types.EventMessage{Event: "slow"}); err != nil {
+		t.Fatal(err)
+	}
+	blocked := make(chan error, 1)
+	go func() { blocked <- d.Dispatch(&types.EventMessage{Event: "slow"}) }()
+	// Give the third Dispatch time to start waiting for room in the queue
+	time.Sleep(20 * time.Millisecond)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- d.Shutdown(context.Background()) }()
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, ErrStopped) {
+			t.Fatalf("expected ErrStopped, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dispatch kept waiting after Shutdown")
+	}
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+}
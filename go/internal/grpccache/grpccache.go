@@ -6,7 +6,6 @@ import (
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/correlation"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
-	"github.com/FatsharkStudiosAB/haja-workers/go/internal/utils"
 	"strconv"
 	"time"
 )
@@ -14,16 +13,20 @@ import (
 // Client provides a cache client over the workflow gRPC stream using correlation IDs
 type Client struct {
 	communicator   communication.WorkflowCommunicator
-	router         *correlation.Router
+	registry       *correlation.Registry
 	defaultTimeout time.Duration
 	serverName     string
 }
 
-// NewClient creates a new gRPC-based cache client
-func NewClient(comm communication.WorkflowCommunicator, serverName string) *Client {
+// NewClient creates a new gRPC-based cache client. Responses are matched through
+// registry, or a registry of its own when nil.
+func NewClient(comm communication.WorkflowCommunicator, serverName string, registry *correlation.Registry) *Client {
+	if registry == nil {
+		registry = correlation.NewRegistry()
+	}
 	return &Client{
 		communicator:   comm,
-		router:         correlation.NewRouter(),
+		registry:       registry,
 		defaultTimeout: 30 * time.Second,
 		serverName:     serverName,
 	}
@@ -36,9 +39,8 @@ func (c *Client) GetByString(ctx context.Context, key string) ([]byte, error) {
 		return nil, fmt.Errorf("grpccache: no communicator configured")
 	}
 
-	correlationID := utils.UID()
-	responseChan := c.router.Register(correlationID, 1)
-	defer c.router.Remove(correlationID)
+	correlationID, responseChan := c.registry.Reserve(types.EventCacheGetRequest, correlation.DeadlineOf(ctx, c.defaultTimeout))
+	defer c.registry.Remove(correlationID)
 
 	meta := map[string]any{
 		"Key":            key,
@@ -57,15 +59,18 @@ func (c *Client) GetByString(ctx context.Context, key string) ([]byte, error) {
 		return nil, fmt.Errorf("grpccache: failed to send cache_get_request: %w", err)
 	}
 
-	select {
-	case resp := <-responseChan:
-		if resp.Payload == nil {
-			return nil, fmt.Errorf("grpccache: empty cache_get_response payload")
-		}
-		return *resp.Payload, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	resp, err := c.registry.Await(ctx, responseChan)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Event == types.EventError {
+		// Fail right away with the remote code and message instead of waiting out the timeout
+		return nil, types.ErrorFromEvent(&resp)
+	}
+	if resp.Payload == nil {
+		return nil, fmt.Errorf("grpccache: empty cache_get_response payload")
 	}
+	return *resp.Payload, nil
 }
 
 // GetUint64 requests a cache value by numeric key
@@ -98,7 +103,7 @@ func (c *Client) Get(key uint64) ([]byte, bool) {
 }
 
 // SetByString stores a cache value with a TTL (in seconds) as part of the Meta and the value in the payload
-// This is sent as a fire-and-forget "cache_set" event; no response is required
+// This is sent as a fire-and-forget "cache_set" event; an acknowledgement is accepted but not awaited
 func (c *Client) SetByString(ctx context.Context, key string, value []byte, ttlSeconds int64) error {
 	if c == nil || c.communicator == nil {
 		return fmt.Errorf("grpccache: no communicator configured")
@@ -119,10 +124,11 @@ func (c *Client) SetByString(ctx context.Context, key string, value []byte, ttlS
 		Text:          "Cache set",
 		Meta:          &meta,
 		Payload:       &payloadCopy,
-		CorrelationID: utils.UID(),
+		CorrelationID: c.registry.Expect(types.EventCacheSet, correlation.DeadlineOf(ctx, c.defaultTimeout)),
 	}
 
 	if err := c.communicator.SendEvent(&event); err != nil {
+		c.registry.Remove(event.CorrelationID)
 		return fmt.Errorf("grpccache: failed to send cache_set: %w", err)
 	}
 	return nil
@@ -154,13 +160,14 @@ func (c *Client) SetWithTTL(key uint64, value []byte, ttl time.Duration) error {
 }
 
 // HandleResponse delivers cache response events to the waiting goroutine using the correlation ID
-// Supported events: cache_get_response (and optionally cache_set_response if server sends it)
+// Supported events: cache_get_response, error (failing the request with the remote error)
+// and optionally cache_set_response if server sends it
 func (c *Client) HandleResponse(response types.EventMessage) {
 	if c == nil {
 		return
 	}
-	if response.Event != types.EventCacheGetResponse && response.Event != types.EventCacheSetResponse {
+	if response.Event != types.EventCacheGetResponse && response.Event != types.EventCacheSetResponse && response.Event != types.EventError {
 		return
 	}
-	c.router.Deliver(response.CorrelationID, response)
+	c.registry.Deliver(response)
 }
@@ -6,23 +6,26 @@ import (
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/correlation"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
-	"github.com/FatsharkStudiosAB/haja-workers/go/internal/utils"
 	"time"
 )
 
 // Client provides a store client over the workflow gRPC stream using correlation IDs
 type Client struct {
 	communicator   communication.WorkflowCommunicator
-	router         *correlation.Router
+	registry       *correlation.Registry
 	defaultTimeout time.Duration
 	serverName     string
 }
 
-// NewClient creates a new gRPC-based store client
-func NewClient(comm communication.WorkflowCommunicator, serverName string) *Client {
+// NewClient creates a new gRPC-based store client. Responses are matched through
+// registry, or a registry of its own when nil.
+func NewClient(comm communication.WorkflowCommunicator, serverName string, registry *correlation.Registry) *Client {
+	if registry == nil {
+		registry = correlation.NewRegistry()
+	}
 	return &Client{
 		communicator:   comm,
-		router:         correlation.NewRouter(),
+		registry:       registry,
 		defaultTimeout: 30 * time.Second,
 		serverName:     serverName,
 	}
@@ -35,9 +38,8 @@ func (c *Client) Get(ctx context.Context, workflowID, key string) ([]byte, error
 		return nil, fmt.Errorf("grpcstore: no communicator configured")
 	}
 
-	correlationID := utils.UID()
-	responseChan := c.router.Register(correlationID, 1)
-	defer c.router.Remove(correlationID)
+	correlationID, responseChan := c.registry.Reserve(types.EventStoreGetRequest, correlation.DeadlineOf(ctx, c.defaultTimeout))
+	defer c.registry.Remove(correlationID)
 
 	meta := map[string]any{
 		"Workflow":       workflowID,
@@ -58,15 +60,18 @@ func (c *Client) Get(ctx context.Context, workflowID, key string) ([]byte, error
 		return nil, fmt.Errorf("grpcstore: failed to send store_get_request: %w", err)
 	}
 
-	select {
-	case resp := <-responseChan:
-		if resp.Payload == nil {
-			return nil, fmt.Errorf("grpcstore: empty store_get_response payload")
-		}
-		return *resp.Payload, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	resp, err := c.registry.Await(ctx, responseChan)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Event == types.EventError {
+		// Fail right away with the remote code and message instead of waiting out the timeout
+		return nil, types.ErrorFromEvent(&resp)
+	}
+	if resp.Payload == nil {
+		return nil, fmt.Errorf("grpcstore: empty store_get_response payload")
 	}
+	return *resp.Payload, nil
 }
 
 // GetString is a convenience to return string data
@@ -85,7 +90,7 @@ func (c *Client) GetWithTimeout(workflowID, key string) ([]byte, error) {
 	return c.Get(ctx, workflowID, key)
 }
 
-// Set stores a value for a workflow/key. This is fire-and-forget by default.
+// Set stores a value for a workflow/key. This is fire-and-forget; an acknowledgement is accepted but not awaited.
 func (c *Client) Set(ctx context.Context, workflowID, key string, value []byte) error {
 	if c == nil || c.communicator == nil {
 		return fmt.Errorf("grpcstore: no communicator configured")
@@ -106,10 +111,11 @@ func (c *Client) Set(ctx context.Context, workflowID, key string, value []byte)
 		Text:          "Store set request",
 		Meta:          &meta,
 		Payload:       &payloadCopy,
-		CorrelationID: utils.UID(),
+		CorrelationID: c.registry.Expect(types.EventStoreSetRequest, correlation.DeadlineOf(ctx, c.defaultTimeout)),
 	}
 
 	if err := c.communicator.SendEvent(&event); err != nil {
+		c.registry.Remove(event.CorrelationID)
 		return fmt.Errorf("grpcstore: failed to send store_set_request: %w", err)
 	}
 	return nil
@@ -126,13 +132,14 @@ func (c *Client) SetWithTimeout(workflowID, key string, value []byte) error {
 }
 
 // HandleResponse delivers store response events to the waiting goroutine using the correlation ID
-// Supported events: store_get_response (and optionally store_set_response if server sends it)
+// Supported events: store_get_response, error (failing the request with the remote error)
+// and optionally store_set_response if server sends it
 func (c *Client) HandleResponse(response types.EventMessage) {
 	if c == nil {
 		return
 	}
-	if response.Event != types.EventStoreGetResponse && response.Event != types.EventStoreSetResponse {
+	if response.Event != types.EventStoreGetResponse && response.Event != types.EventStoreSetResponse && response.Event != types.EventError {
 		return
 	}
-	c.router.Deliver(response.CorrelationID, response)
+	c.registry.Deliver(response)
 }
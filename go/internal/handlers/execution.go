@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/rpc"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"log"
+	"time"
+)
+
+var (
+	// ErrExecutionCancelled is the cancellation cause used when the workflow cancels a run
+	ErrExecutionCancelled = errors.New("execution cancelled by workflow")
+
+	// ErrExecutionTimeout is the cancellation cause used when an execution exceeds its deadline
+	ErrExecutionTimeout = errors.New("execution timed out")
+)
+
+// cancelTombstoneTTL bounds how long a cancel for a request that has not started yet is
+// remembered; requests still queued after that run normally
+const cancelTombstoneTTL = 10 * time.Minute
+
+// executionResult carries the outcome of a function execution back to the dispatcher worker
+type executionResult struct {
+	outputs *[]byte
+	err     error
+}
+
+// executeFunction runs a function with a cancellable context registered under the
+// request's correlation ID and sends the response or error back to the caller.
+// The context ends at the earlier of the function's timeout and the caller's deadline;
+// once it ends the caller is answered, while the dispatcher worker stays taken until
+// the handler returns.
+func executeFunction(gs *state.GlobalState, fs *state.EventState, function basefunction.FunctionInterface, message *types.EventMessage) {
+	ctx, cancel := context.WithCancelCause(rpc.NewContext(context.Background(), gs.RpcClient, message))
+	gs.Executions.Store(message.CorrelationID, cancel)
+	defer func() {
+		gs.Executions.Delete(message.CorrelationID)
+		cancel(nil)
+	}()
+	// The workflow may have cancelled the request while it was queued
+	if _, cancelled := gs.CancelledRequests.LoadAndDelete(message.CorrelationID); cancelled {
+		log.Printf("Skipping %s, cancelled before it started (correlation ID: %s)", message.Function, message.CorrelationID)
+		SendError(gs, fs, types.Errorf(types.ErrorCodeCancelled, "Function execution cancelled: %v", ErrExecutionCancelled))
+		return
+	}
+
+	outputs, sdkErr, overrun := runFunction(ctx, gs, getFunctionKey(fs), function, message)
+	if overrun != nil {
+		// The caller is answered right away, but the dispatcher slot stays taken until the
+		// handler returns so that concurrency limits bound the work that is really running
+		defer awaitOverrun(message, overrun)
+	}
+	if sdkErr != nil {
+		SendError(gs, fs, sdkErr)
+		return
+	}
+	fn, ok := function.(interface{ IsStreaming() bool })
+	sendFunctionResponse(gs, fs, outputs, ok && fn.IsStreaming())
+}
+
+// Execute runs function in process on behalf of message, a function_request built by
+// the caller, the way a request from the workflow server runs: it waits for one of the
+// function's concurrency slots, ends at the function's timeout or the deadline in the
+// message meta, and counts panics towards MaxFunctionPanics. It returns once ctx ends,
+// while the slot stays taken until the handler returns. Errors are *types.Error values.
+func Execute(ctx context.Context, gs *state.GlobalState, function basefunction.FunctionInterface, message *types.EventMessage) (*[]byte, error) {
+	release := func() {}
+	if gs.Dispatcher != nil {
+		var err error
+		if release, err = gs.Dispatcher.Acquire(ctx, message); err != nil {
+			return nil, types.Errorf(types.ErrorCodeCancelled, "Function execution cancelled while waiting for a free slot: %v", err)
+		}
+	}
+	outputs, sdkErr, overrun := runFunction(ctx, gs, types.FunctionKey(gs.ServerName, message.Function, message.Version), function, message)
+	if overrun != nil {
+		go func() {
+			awaitOverrun(message, overrun)
+			release()
+		}()
+	} else {
+		release()
+	}
+	if sdkErr != nil {
+		return nil, sdkErr
+	}
+	return outputs, nil
+}
+
+// runFunction executes function until it returns or ctx ends, whichever comes first,
+// and classifies the outcome. Panics count towards disabling the function under
+// functionKey. When ctx ends first, overrun is closed once the handler returns.
+func runFunction(ctx context.Context, gs *state.GlobalState, functionKey string, function basefunction.FunctionInterface, message *types.EventMessage) (*[]byte, *types.Error, <-chan struct{}) {
+	deadline, hasDeadline := executionDeadline(function, message)
+	if hasDeadline {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadlineCause(ctx, deadline, ErrExecutionTimeout)
+		defer cancelDeadline()
+	}
+
+	done := make(chan executionResult, 1)
+	returned := make(chan struct{})
+	go func() {
+		var result executionResult
+		defer func() {
+			// Functions built with basefunction recover their own handler panics;
+			// this guards other FunctionInterface implementations
+			if r := recover(); r != nil {
+				result = executionResult{err: basefunction.NewPanicError(r)}
+			}
+			close(returned)
+			done <- result
+		}()
+		result.outputs, result.err = function.ExecuteContext(ctx, message.Payload, message)
+	}()
+
+	var result executionResult
+	var overrun <-chan struct{}
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = executionResult{err: context.Cause(ctx)}
+		overrun = returned
+	}
+
+	if result.err == nil {
+		recordSuccess(gs, functionKey)
+		return result.outputs, nil, nil
+	}
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, ErrExecutionTimeout):
+		log.Printf("Function %s timed out (correlation ID: %s)", message.Function, message.CorrelationID)
+		return nil, types.Errorf(types.ErrorCodeTimeout, "Function execution timed out: deadline %s exceeded", deadline.UTC().Format(time.RFC3339)).
+			WithDetails(map[string]any{types.MetaDeadline: deadline.UTC().Format(time.RFC3339Nano)}), overrun
+	case cause != nil:
+		return nil, types.Errorf(types.ErrorCodeCancelled, "Function execution cancelled: %v", cause), overrun
+	}
+	var panicErr *basefunction.PanicError
+	if errors.As(result.err, &panicErr) {
+		recordPanic(gs, functionKey, panicErr)
+		return nil, panicError(panicErr), nil
+	}
+	return nil, executionError(result.err), nil
+}
+
+// awaitOverrun waits for a handler that is still running after its context ended
+func awaitOverrun(message *types.EventMessage, returned <-chan struct{}) {
+	select {
+	case <-returned:
+		return
+	default:
+	}
+	log.Printf("Function %s ignores its cancelled context, holding its handler slot until it returns (correlation ID: %s)", message.Function, message.CorrelationID)
+	start := time.Now()
+	<-returned
+	log.Printf("Function %s returned %v after its context ended (correlation ID: %s)", message.Function, time.Since(start).Round(time.Millisecond), message.CorrelationID)
+}
+
+// executionError keeps structured errors returned by the function and classifies
+// anything else as an internal error
+func executionError(err error) *types.Error {
+	if sdkErr := types.AsError(err); sdkErr != nil {
+		return sdkErr
+	}
+	return types.Errorf(types.ErrorCodeInternal, "Function execution failed: %v", err)
+}
+
+// executionDeadline returns the earlier of the function's own timeout and the
+// deadline requested by the caller in the event meta
+func executionDeadline(function basefunction.FunctionInterface, message *types.EventMessage) (time.Time, bool) {
+	var deadline time.Time
+	if fn, ok := function.(interface{ GetTimeout() time.Duration }); ok && fn.GetTimeout() > 0 {
+		deadline = time.Now().Add(fn.GetTimeout())
+	}
+	if callerDeadline, ok := deadlineFromMeta(message.Meta); ok {
+		if deadline.IsZero() || callerDeadline.Before(deadline) {
+			deadline = callerDeadline
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+// deadlineFromMeta parses the caller deadline, given either as an RFC 3339
+// timestamp or as Unix time in milliseconds
+func deadlineFromMeta(meta *map[string]any) (time.Time, bool) {
+	if meta == nil {
+		return time.Time{}, false
+	}
+	switch v := (*meta)[types.MetaDeadline].(type) {
+	case string:
+		if v == "" {
+			break
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+		log.Printf("Ignoring malformed deadline in event meta: %q", v)
+	case float64:
+		return time.UnixMilli(int64(v)), true
+	}
+	return time.Time{}, false
+}
+
+// handleFunctionCancel cancels the running execution matching the event's correlation ID.
+// A request that has not started yet is remembered as cancelled and skipped when its
+// turn comes.
+func handleFunctionCancel(gs *state.GlobalState, message *types.EventMessage) {
+	if cancel, ok := gs.Executions.Load(message.CorrelationID); ok {
+		log.Printf("Cancelling execution of %s with correlation ID: %s", message.Function, message.CorrelationID)
+		cancel(ErrExecutionCancelled)
+		return
+	}
+	log.Printf("No running execution for cancel request with correlation ID: %s, cancelling it before it starts", message.CorrelationID)
+	now := time.Now()
+	var expired []string
+	gs.CancelledRequests.Range(func(correlationID string, at time.Time) bool {
+		if now.Sub(at) > cancelTombstoneTTL {
+			expired = append(expired, correlationID)
+		}
+		return true
+	})
+	for _, correlationID := range expired {
+		gs.CancelledRequests.Delete(correlationID)
+	}
+	gs.CancelledRequests.Store(message.CorrelationID, now)
+	// The execution may have started between the two lookups
+	if cancel, ok := gs.Executions.Load(message.CorrelationID); ok {
+		if _, pending := gs.CancelledRequests.LoadAndDelete(message.CorrelationID); pending {
+			cancel(ErrExecutionCancelled)
+		}
+	}
+}
+
+// CancelExecutions cancels every running execution with the given cause
+func CancelExecutions(gs *state.GlobalState, cause error) {
+	gs.Executions.Range(func(correlationID string, cancel context.CancelCauseFunc) bool {
+		log.Printf("Cancelling execution with correlation ID: %s", correlationID)
+		cancel(cause)
+		return true
+	})
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+func TestDeadlineFromMeta(t *testing.T) {
+	deadline := time.Date(2030, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+	cases := []struct {
+		name  string
+		value any
+		want  time.Time
+		ok    bool
+	}{
+		{"rfc3339", deadline.Format(time.RFC3339Nano), deadline, true},
+		{"unix milliseconds", float64(deadline.UnixMilli()), deadline, true},
+		{"empty", "", time.Time{}, false},
+		{"malformed", "tomorrow", time.Time{}, false},
+		{"missing", nil, time.Time{}, false},
+	}
+	for _, tc := range cases {
+		meta := map[string]any{}
+		if tc.value != nil {
+			meta[types.MetaDeadline] = tc.value
+		}
+		got, ok := deadlineFromMeta(&meta)
+		if ok != tc.ok || !got.Equal(tc.want) {
+			t.Errorf("%s: got %v, %v; want %v, %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+	if _, ok := deadlineFromMeta(nil); ok {
+		t.Errorf("nil meta must not carry a deadline")
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"log"
+	"time"
+)
+
+// CurrentCapacity reports the worker's capacity from the dispatcher and the
+// concurrency limits of the registered functions
+func CurrentCapacity(gs *state.GlobalState) types.Capacity {
+	var capacity types.Capacity
+	if gs.Dispatcher != nil {
+		stats := gs.Dispatcher.Stats()
+		capacity.HandlersConcurrency = stats.Workers
+		capacity.InFlight = stats.InFlight
+		capacity.QueueDepth = stats.Queued
+		capacity.QueueCapacity = stats.Capacity
+	}
+	gs.Functions.Range(func(key string, function basefunction.FunctionInterface) bool {
+		if fn, ok := function.(interface{ GetMaxConcurrency() int }); ok && fn.GetMaxConcurrency() > 0 {
+			if capacity.FunctionConcurrency == nil {
+				capacity.FunctionConcurrency = make(map[string]int)
+			}
+			capacity.FunctionConcurrency[function.GetName()+":"+function.GetVersion()] = fn.GetMaxConcurrency()
+		}
+		return true
+	})
+	return capacity
+}
+
+// capacityMeta returns event meta carrying the current capacity
+func capacityMeta(gs *state.GlobalState) *map[string]any {
+	meta := map[string]any{types.MetaCapacity: CurrentCapacity(gs).ToMeta()}
+	return &meta
+}
+
+// SendHeartbeat sends the current capacity to the workflow server
+func SendHeartbeat(gs *state.GlobalState) {
+	event := types.EventMessage{
+		Server:        gs.ServerName,
+		Event:         types.EventHeartbeat,
+		Text:          "Heartbeat",
+		Meta:          capacityMeta(gs),
+		CorrelationID: "heartbeat",
+	}
+
+	if err := gs.WorkflowComm.SendEvent(&event); err != nil {
+		log.Printf("Failed to send heartbeat: %v", err)
+	}
+}
+
+// RunHeartbeat sends a heartbeat whenever the connection is (re-)established and then
+// every interval until ctx is done. Heartbeats are skipped while disconnected.
+func RunHeartbeat(ctx context.Context, gs *state.GlobalState, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	states, unsubscribe := gs.WorkflowComm.SubscribeState()
+	defer unsubscribe()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case connState, ok := <-states:
+			if !ok {
+				return
+			}
+			if connState == communication.StateConnected {
+				SendHeartbeat(gs)
+			}
+		case <-ticker.C:
+			if gs.WorkflowComm.IsConnected() {
+				SendHeartbeat(gs)
+			}
+		}
+	}
+}
@@ -55,7 +55,7 @@ func handleServerName(gs *state.GlobalState, fs *state.EventState) {
 		Server:        gs.ServerName,
 		Event:         types.EventResponseServerName,
 		Text:          gs.ServerName,
-		Meta:          nil,
+		Meta:          capacityMeta(gs),
 		Payload:       nil,
 		CorrelationID: fs.CorrelationID,
 	}
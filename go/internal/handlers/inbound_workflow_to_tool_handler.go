@@ -1,66 +1,78 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/dispatcher"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
 	"log"
+	"slices"
 )
 
 func HandleIncomingWorkflow(gs *state.GlobalState) {
-	// Register handlers on dispatcher
+	gs.Dispatcher.SetPanicHandler(func(msg *types.EventMessage, recovered any, stack []byte) {
+		handleDispatcherPanic(gs, msg, recovered, stack)
+	})
+
+	// Functions with a concurrency limit only run that many executions at once
+	gs.Dispatcher.SetLimitFunc(func(message *types.EventMessage) (string, int) {
+		functionKey := types.FunctionKey(gs.ServerName, message.Function, message.Version)
+		if function, ok := gs.Functions.Load(functionKey); ok {
+			if fn, ok := function.(interface{ GetMaxConcurrency() int }); ok {
+				return functionKey, fn.GetMaxConcurrency()
+			}
+		}
+		return functionKey, 0
+	})
+
+	// Function executions run on the execution lane; responses and control events run on
+	// the control lane so they never wait behind executions that may be waiting for them
 	gs.Dispatcher.Register(types.EventFunctionRequest, func(message *types.EventMessage) {
 		fs := state.NewEventState(message.Server, message.Function, message.Version, message.Node, message.Workflow, message.Run, gs.ServerName, message.CorrelationID)
 		log.Println("Received function request: " + message.Function)
 		functionKey := getFunctionKey(fs)
 		function, ok := gs.Functions.Load(functionKey)
 		if !ok {
-			sendErrorEvent(gs, fs, "Function not found")
+			if reason, disabled := gs.DisabledFunctions.Load(functionKey); disabled {
+				SendError(gs, fs, types.NewError(types.ErrorCodeUnavailable, "Function "+reason))
+				return
+			}
+			SendError(gs, fs, types.NewError(types.ErrorCodeNotFound, "Function not found"))
 			return
 		}
-		outputs, err := function.Execute(message.Payload, message)
-		if err != nil {
-			sendErrorEvent(gs, fs, fmt.Sprintf("Function execution failed: %v", err))
-			return
-		}
-		sendFunctionResponse(gs, fs, outputs)
+		executeFunction(gs, fs, function, message)
 	})
 
-	gs.Dispatcher.Register(types.EventFunctionResponse, func(message *types.EventMessage) {
-		gs.RpcClient.HandleCallResponse(*message)
-	})
-
-	gs.Dispatcher.Register(types.EventCacheGetResponse, func(message *types.EventMessage) {
-		if gs.GrpcCache != nil {
-			gs.GrpcCache.HandleResponse(*message)
-		}
-	})
-	gs.Dispatcher.Register(types.EventCacheSetResponse, func(message *types.EventMessage) {
-		if gs.GrpcCache != nil {
-			gs.GrpcCache.HandleResponse(*message)
-		}
-	})
-
-	gs.Dispatcher.Register(types.EventStoreGetResponse, func(message *types.EventMessage) {
-		if gs.GrpcStore != nil {
-			gs.GrpcStore.HandleResponse(*message)
-		}
-	})
-	gs.Dispatcher.Register(types.EventStoreSetResponse, func(message *types.EventMessage) {
-		if gs.GrpcStore != nil {
-			gs.GrpcStore.HandleResponse(*message)
-		}
-	})
+	// Correlated responses normally bypass the dispatcher through the communicator's
+	// response handler; the control lane handles them for communicators without one
+	for _, event := range responseEvents {
+		gs.Dispatcher.RegisterControl(event, func(message *types.EventMessage) {
+			RouteResponse(gs, message)
+		})
+	}
+	// Status messages and chunks followed by InvokeFlow and InvokeStream
+	for _, event := range progressEvents {
+		gs.Dispatcher.RegisterControl(event, func(message *types.EventMessage) {
+			RouteResponse(gs, message)
+		})
+	}
+	if comm, ok := gs.WorkflowComm.(interface {
+		SetResponseHandler(func(*types.EventMessage) bool)
+	}); ok {
+		comm.SetResponseHandler(func(message *types.EventMessage) bool {
+			return RouteResponse(gs, message)
+		})
+	}
 
-	gs.Dispatcher.Register(types.EventRequestListFunctions, func(message *types.EventMessage) {
+	gs.Dispatcher.RegisterControl(types.EventRequestListFunctions, func(message *types.EventMessage) {
 		fs := state.NewEventState(message.Server, message.Function, message.Version, message.Node, message.Workflow, message.Run, gs.ServerName, message.CorrelationID)
 		HandleListFunctions(gs, fs)
 	})
-	gs.Dispatcher.Register(types.EventRequestServerName, func(message *types.EventMessage) {
+	gs.Dispatcher.RegisterControl(types.EventRequestServerName, func(message *types.EventMessage) {
 		fs := state.NewEventState(message.Server, message.Function, message.Version, message.Node, message.Workflow, message.Run, gs.ServerName, message.CorrelationID)
 		handleServerName(gs, fs)
 	})
-	gs.Dispatcher.Register(types.EventRequestServerInfo, func(message *types.EventMessage) {
+	gs.Dispatcher.RegisterControl(types.EventRequestServerInfo, func(message *types.EventMessage) {
 		fs := state.NewEventState(message.Server, message.Function, message.Version, message.Node, message.Workflow, message.Run, gs.ServerName, message.CorrelationID)
 		handleServerName(gs, fs)
 		HandleListFunctions(gs, fs)
@@ -70,34 +82,67 @@ func HandleIncomingWorkflow(gs *state.GlobalState) {
 	incomingEvents := gs.WorkflowComm.ReceiveEvents()
 	for msg := range incomingEvents {
 		log.Println("Received workflow message with event: " + msg.Event + " and workflow: " + msg.Workflow)
-		if msg.Workflow == "" && msg.Event != types.EventCacheGetResponse && msg.Event != types.EventCacheSetResponse && msg.Event != types.EventStoreGetResponse && msg.Event != types.EventStoreSetResponse && msg.Event != types.EventRequestServerInfo && msg.Event != types.EventRequestServerName && msg.Event != types.EventRequestListFunctions {
+		// Cancellations are handled inline so they are not stuck behind the
+		// very executions they are meant to cancel
+		if msg.Event == types.EventFunctionCancel {
+			handleFunctionCancel(gs, msg)
+			continue
+		}
+		// Responses (including errors answering cache and store requests), chunks and
+		// server requests are not tied to a workflow
+		if msg.Workflow == "" && !slices.Contains(responseEvents, msg.Event) && msg.Event != types.EventFunctionChunk && msg.Event != types.EventRequestServerInfo && msg.Event != types.EventRequestServerName && msg.Event != types.EventRequestListFunctions {
 			log.Println("Workflow is empty, skipping")
 			continue
 		}
-		gs.Dispatcher.Dispatch(msg)
+		// Function requests are refused when the queue is full so the workflow server
+		// can reroute them. Everything else is awaited by running handlers and waits
+		// for a free control worker, which in turn stops reading from the stream.
+		if msg.Event == types.EventFunctionRequest {
+			err := gs.Dispatcher.TryDispatch(msg)
+			if errors.Is(err, dispatcher.ErrOverloaded) {
+				rejectOverloaded(gs, msg)
+				continue
+			}
+			if err != nil {
+				handleUndispatched(gs, msg)
+			}
+			continue
+		}
+		if err := gs.Dispatcher.Dispatch(msg); err != nil {
+			handleUndispatched(gs, msg)
+		}
 	}
 }
 
-func sendErrorEvent(gs *state.GlobalState, fs *state.EventState, errorText string) {
-	event := types.EventMessage{
-		Function:      fs.Function,
-		Version:       fs.Version,
-		Node:          fs.Node,
-		Workflow:      fs.Workflow,
-		Run:           fs.Run,
-		Event:         types.EventError,
-		Text:          errorText,
-		Meta:          nil,
-		Payload:       nil,
-		CorrelationID: fs.CorrelationID,
-	}
+// rejectOverloaded answers a function request that found the dispatcher queue full
+func rejectOverloaded(gs *state.GlobalState, msg *types.EventMessage) {
+	stats := gs.Dispatcher.Stats()
+	log.Printf("Rejecting function request for %s (correlation ID: %s): worker overloaded, %d queued, %d rejected so far", msg.Function, msg.CorrelationID, stats.Queued, stats.Rejected)
+	fs := state.NewEventState(msg.Server, msg.Function, msg.Version, msg.Node, msg.Workflow, msg.Run, gs.ServerName, msg.CorrelationID)
+	SendError(gs, fs, types.Errorf(types.ErrorCodeOverloaded, "Worker %s is overloaded, request rejected", gs.ServerName).
+		WithDetails(map[string]any{"queued": stats.Queued, "capacity": stats.Capacity}))
+}
 
-	if err := gs.WorkflowComm.SendEvent(&event); err != nil {
-		log.Printf("Failed to send error event: %v", err)
+// handleUndispatched deals with events that arrive after the dispatcher has stopped.
+// New function requests are refused so the workflow server can route them elsewhere,
+// while everything else (notably responses awaited by in-flight handlers) is still
+// handled inline so that draining executions can complete.
+func handleUndispatched(gs *state.GlobalState, msg *types.EventMessage) {
+	if msg.Event == types.EventFunctionRequest {
+		fs := state.NewEventState(msg.Server, msg.Function, msg.Version, msg.Node, msg.Workflow, msg.Run, gs.ServerName, msg.CorrelationID)
+		SendError(gs, fs, types.NewError(types.ErrorCodeUnavailable, "Worker is shutting down"))
+		return
 	}
+	gs.Dispatcher.Run(msg)
 }
 
-func sendFunctionResponse(gs *state.GlobalState, fs *state.EventState, payload *[]byte) {
+// sendFunctionResponse answers the request with the function's outputs. The response of
+// a streaming function is marked so the caller knows to expect the final chunk.
+func sendFunctionResponse(gs *state.GlobalState, fs *state.EventState, payload *[]byte, streamed bool) {
+	var meta *map[string]any
+	if streamed {
+		meta = &map[string]any{types.MetaStreamed: true}
+	}
 	event := types.EventMessage{
 		Function:      fs.Function,
 		Version:       fs.Version,
@@ -106,7 +151,7 @@ func sendFunctionResponse(gs *state.GlobalState, fs *state.EventState, payload *
 		Run:           fs.Run,
 		Event:         types.EventFunctionResponse,
 		Text:          "",
-		Meta:          nil,
+		Meta:          meta,
 		Payload:       payload,
 		CorrelationID: fs.CorrelationID,
 	}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// panicError converts a recovered handler panic into an internal error whose
+// details carry the panic value and a digest of the stack trace
+func panicError(panicErr *basefunction.PanicError) *types.Error {
+	return types.Errorf(types.ErrorCodeInternal, "Function execution failed: %v", panicErr).
+		WithDetails(map[string]any{
+			"panic":        fmt.Sprint(panicErr.Value),
+			"stack_digest": panicErr.Digest(),
+			"stack":        panicErr.Frames(),
+		})
+}
+
+// recordPanic counts a panic of the given function and disables the function once it
+// has panicked MaxFunctionPanics times in a row
+func recordPanic(gs *state.GlobalState, functionKey string, panicErr *basefunction.PanicError) {
+	counter, _ := gs.FunctionPanics.LoadOrStore(functionKey, &atomic.Int64{})
+	count := counter.Add(1)
+	log.Printf("Function %s panicked (%d in a row, digest %s): %v\n%s", functionKey, count, panicErr.Digest(), panicErr.Value, panicErr.Stack)
+
+	if gs.MaxFunctionPanics > 0 && count >= int64(gs.MaxFunctionPanics) {
+		disableFunction(gs, functionKey, fmt.Sprintf("disabled after %d consecutive panics (last: %v)", count, panicErr.Value))
+	}
+}
+
+// recordSuccess resets the consecutive panic count of a function
+func recordSuccess(gs *state.GlobalState, functionKey string) {
+	if counter, ok := gs.FunctionPanics.Load(functionKey); ok {
+		counter.Store(0)
+	}
+}
+
+// disableFunction removes a function from the registry and re-publishes the function
+// list so the workflow server stops routing requests to it
+func disableFunction(gs *state.GlobalState, functionKey, reason string) {
+	if _, loaded := gs.Functions.LoadAndDelete(functionKey); !loaded {
+		return
+	}
+	gs.DisabledFunctions.Store(functionKey, reason)
+	log.Printf("Function %s %s", functionKey, reason)
+
+	HandleListFunctions(gs, &state.EventState{
+		Server:         gs.ServerName,
+		FunctionServer: gs.ServerName,
+		CorrelationID:  "function_disabled",
+	})
+}
+
+// handleDispatcherPanic reports a panic outside of function execution to the
+// sender of the request, if the event was a request that expects an answer
+func handleDispatcherPanic(gs *state.GlobalState, msg *types.EventMessage, recovered any, stack []byte) {
+	if msg.Event != types.EventFunctionRequest && !strings.HasPrefix(msg.Event, "request_") {
+		return
+	}
+	fs := state.NewEventState(msg.Server, msg.Function, msg.Version, msg.Node, msg.Workflow, msg.Run, gs.ServerName, msg.CorrelationID)
+	SendError(gs, fs, panicError(&basefunction.PanicError{Value: recovered, Stack: stack}))
+}
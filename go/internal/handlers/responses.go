@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"slices"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+// responseEvents are the events answering a request made by this worker
+var responseEvents = []string{
+	types.EventFunctionResponse,
+	types.EventError,
+	types.EventCacheGetResponse,
+	types.EventCacheSetResponse,
+	types.EventStoreGetResponse,
+	types.EventStoreSetResponse,
+}
+
+// progressEvents are sent under the correlation ID of a request before its response
+var progressEvents = []string{
+	types.EventStatusMessage,
+	types.EventFunctionChunk,
+}
+
+// RouteResponse delivers a correlated response to the request waiting for it and reports
+// whether msg was a response. Status messages and function chunks count as responses
+// only while a request made with InvokeFlow or InvokeStream follows them. It runs on
+// the goroutine reading from the stream, so responses reach handlers blocked in
+// RpcClient.Call, GrpcCache or GrpcStore even while every dispatcher worker is busy
+// running such handlers.
+func RouteResponse(gs *state.GlobalState, msg *types.EventMessage) bool {
+	if slices.Contains(progressEvents, msg.Event) {
+		return gs.Correlations != nil && gs.Correlations.Notify(*msg)
+	}
+	if !slices.Contains(responseEvents, msg.Event) {
+		return false
+	}
+	// RpcClient, GrpcCache and GrpcStore share one registry of pending requests
+	if gs.Correlations != nil {
+		gs.Correlations.Deliver(*msg)
+	}
+	return true
+}
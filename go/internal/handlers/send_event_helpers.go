@@ -6,7 +6,13 @@ import (
 	"log"
 )
 
+// SendErrorEvent sends an internal error with the given text
 func SendErrorEvent(gs *state.GlobalState, fs *state.EventState, errorText string) {
+	SendError(gs, fs, types.NewError(types.ErrorCodeInternal, errorText))
+}
+
+// SendError sends a structured error event; the error message doubles as the event text
+func SendError(gs *state.GlobalState, fs *state.EventState, sdkErr *types.Error) {
 	event := types.EventMessage{
 		Server:        gs.ServerName,
 		Function:      fs.Function,
@@ -15,11 +21,12 @@ func SendErrorEvent(gs *state.GlobalState, fs *state.EventState, errorText strin
 		Workflow:      fs.Workflow,
 		Run:           fs.Run,
 		Event:         types.EventError,
-		Text:          errorText,
+		Text:          sdkErr.Message,
 		Meta:          nil,
 		Payload:       nil,
 		CorrelationID: fs.CorrelationID,
 	}
+	sdkErr.ToEvent(&event)
 
 	if err := gs.WorkflowComm.SendEvent(&event); err != nil {
 		log.Printf("Failed to send error event: %v", err)
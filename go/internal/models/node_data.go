@@ -76,18 +76,32 @@ type ParameterDefinition struct {
 	Required   []string                   `json:"required,omitempty"`
 }
 
-// PropertyDetails represents the details of each property in parameters
+// PropertyDetails represents the details of each property in parameters.
+// Object properties describe their fields in Properties and Required, maps their
+// values in AdditionalProperties. An empty Type accepts any value and is left out.
 type PropertyDetails struct {
-	Type        string     `json:"type"`
-	Description string     `json:"description,omitempty"`
-	Enum        []string   `json:"enum,omitempty"`
-	Items       *ItemsSpec `json:"items,omitempty"`
+	Type                 string                     `json:"type"`
+	Description          string                     `json:"description,omitempty"`
+	Format               string                     `json:"format,omitempty"`
+	Enum                 []string                   `json:"enum,omitempty"`
+	Minimum              *float64                   `json:"minimum,omitempty"`
+	Maximum              *float64                   `json:"maximum,omitempty"`
+	Items                *ItemsSpec                 `json:"items,omitempty"`
+	Properties           map[string]PropertyDetails `json:"properties,omitempty"`
+	Required             []string                   `json:"required,omitempty"`
+	AdditionalProperties *PropertyDetails           `json:"additionalProperties,omitempty"`
 }
 
 // ItemsSpec represents the items specification for array types
 type ItemsSpec struct {
-	Type string   `json:"type"`
-	Enum []string `json:"enum,omitempty"`
+	Type                 string                     `json:"type"`
+	Description          string                     `json:"description,omitempty"`
+	Format               string                     `json:"format,omitempty"`
+	Enum                 []string                   `json:"enum,omitempty"`
+	Items                *ItemsSpec                 `json:"items,omitempty"`
+	Properties           map[string]PropertyDetails `json:"properties,omitempty"`
+	Required             []string                   `json:"required,omitempty"`
+	AdditionalProperties *PropertyDetails           `json:"additionalProperties,omitempty"`
 }
 
 // NodeData contains the main configuration of a node
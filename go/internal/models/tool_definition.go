@@ -0,0 +1,156 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+)
+
+// invalidToolNameChars matches characters not allowed in OpenAI tool names (^[a-zA-Z0-9_-]{1,64}$)
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// ToolName turns an arbitrary string into a valid tool name
+func ToolName(name string) string {
+	name = invalidToolNameChars.ReplaceAllString(name, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// NewToolDefinition builds an OpenAI-style function tool from a function definition.
+// The parameters are derived from the published input schema, which must describe an object.
+func NewToolDefinition(def basefunction.FunctionDefinition) (*ToolDefinition, error) {
+	if len(def.InputSchema) == 0 {
+		return nil, fmt.Errorf("function %s has no input schema", def.Name)
+	}
+	var schema basefunction.JSONSchema
+	if err := json.Unmarshal(def.InputSchema, &schema); err != nil {
+		return nil, fmt.Errorf("invalid input schema for function %s: %w", def.Name, err)
+	}
+	if schema.Type != "object" {
+		return nil, fmt.Errorf("function %s takes %q input, tools require an object", def.Name, schema.Type)
+	}
+
+	converter := toolSchemaConverter{root: &schema, visiting: map[*basefunction.JSONSchema]bool{}}
+	root := converter.property(&schema)
+	if root.Properties == nil {
+		root.Properties = map[string]PropertyDetails{}
+	}
+
+	return &ToolDefinition{
+		Type: "function",
+		Function: FunctionToolSpec{
+			Name:        ToolName(def.Name),
+			Description: def.Description,
+			Parameters: ParameterDefinition{
+				Type:       "object",
+				Properties: root.Properties,
+				Required:   root.Required,
+			},
+		},
+	}, nil
+}
+
+// toolSchemaConverter maps a JSON Schema onto the tool parameter types, inlining $refs.
+// Recursive types are cut off at the first repetition.
+type toolSchemaConverter struct {
+	root     *basefunction.JSONSchema
+	visiting map[*basefunction.JSONSchema]bool
+}
+
+func (c toolSchemaConverter) property(schema *basefunction.JSONSchema) PropertyDetails {
+	// A field's own description sits next to its $ref and wins over the referenced type's
+	var description string
+	if schema != nil {
+		description = schema.Description
+	}
+	schema = c.root.Resolve(schema)
+	if schema == nil || schema.IsTrue() || schema.IsFalse() {
+		return PropertyDetails{Description: description}
+	}
+	if description == "" {
+		description = schema.Description
+	}
+
+	property := PropertyDetails{
+		Type:        schema.Type,
+		Description: description,
+		Format:      schema.Format,
+		Enum:        stringEnum(schema.Enum),
+		Minimum:     schema.Minimum,
+		Maximum:     schema.Maximum,
+	}
+	if c.visiting[schema] {
+		return property
+	}
+	c.visiting[schema] = true
+	defer delete(c.visiting, schema)
+
+	if len(schema.Properties) > 0 {
+		property.Properties = make(map[string]PropertyDetails, len(schema.Properties))
+		for name, field := range schema.Properties {
+			property.Properties[name] = c.property(field)
+		}
+		property.Required = schema.Required
+	}
+	if schema.Items != nil {
+		property.Items = c.property(schema.Items).itemsSpec()
+	}
+	// Maps describe their values here; closed structs need no counterpart in a tool
+	if schema.AdditionalProperties != nil && !schema.AdditionalProperties.IsFalse() {
+		values := c.property(schema.AdditionalProperties)
+		property.AdditionalProperties = &values
+	}
+	return property
+}
+
+// itemsSpec converts a property into the items specification of an array
+func (p PropertyDetails) itemsSpec() *ItemsSpec {
+	return &ItemsSpec{
+		Type:                 p.Type,
+		Description:          p.Description,
+		Format:               p.Format,
+		Enum:                 p.Enum,
+		Items:                p.Items,
+		Properties:           p.Properties,
+		Required:             p.Required,
+		AdditionalProperties: p.AdditionalProperties,
+	}
+}
+
+// MarshalJSON leaves out an empty type, as {"type":""} is not a valid schema
+func (p PropertyDetails) MarshalJSON() ([]byte, error) {
+	type plain PropertyDetails
+	return json.Marshal(struct {
+		Type string `json:"type,omitempty"`
+		plain
+	}{p.Type, plain(p)})
+}
+
+// MarshalJSON leaves out an empty type like PropertyDetails does
+func (s ItemsSpec) MarshalJSON() ([]byte, error) {
+	type plain ItemsSpec
+	return json.Marshal(struct {
+		Type string `json:"type,omitempty"`
+		plain
+	}{s.Type, plain(s)})
+}
+
+// stringEnum returns the enum values if they are all strings; tool enums only carry strings
+func stringEnum(values []any) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	enum := make([]string, len(values))
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		enum[i] = s
+	}
+	return enum
+}
@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"sync"
+)
+
+// DefaultBatchConcurrency is the number of calls a batch keeps in flight unless configured otherwise
+const DefaultBatchConcurrency = 16
+
+// errBatchFailed is the cancellation cause of calls stopped by a FailFast batch
+var errBatchFailed = errors.New("an earlier call of the batch failed")
+
+// BatchMode decides how a batch reacts to a failed call
+type BatchMode int
+
+const (
+	// CollectErrors runs every call and reports each failure with its input
+	CollectErrors BatchMode = iota
+	// FailFast cancels the calls in flight and skips the rest after the first failure
+	FailFast
+)
+
+// BatchOptions configures InvokeBatch and InvokeAll
+type BatchOptions struct {
+	// Concurrency bounds the calls in flight; 0 means DefaultBatchConcurrency
+	Concurrency int
+	// Mode decides what happens after a call fails
+	Mode BatchMode
+}
+
+// Result is the outcome of the call for inputs[Index]
+type Result[Out any] struct {
+	Index  int
+	Output Out
+	Err    error
+}
+
+// InvokeBatch calls function name at version on server once per input, keeping up to
+// opts.Concurrency requests in flight over the shared stream, and sends each result on
+// the returned channel as soon as it completes. Every input yields exactly one Result;
+// inputs skipped after a FailFast failure or the end of ctx fail with
+// ErrorCodeCancelled. The channel is closed after the last result and is buffered for
+// all of them, so a caller may stop reading early.
+func InvokeBatch[In, Out any](ctx context.Context, server, name, version string, inputs []In, opts BatchOptions) <-chan Result[Out] {
+	results := make(chan Result[Out], len(inputs))
+	limit := opts.Concurrency
+	if limit <= 0 {
+		limit = DefaultBatchConcurrency
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	go func() {
+		defer close(results)
+		defer cancel(nil)
+
+		slots := make(chan struct{}, limit)
+		var wg sync.WaitGroup
+		for i, in := range inputs {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				// Nothing starts once ctx is done, so a slot taken here is never missed
+				results <- Result[Out]{Index: i, Err: types.Errorf(types.ErrorCodeCancelled, "rpc: %s@%s not called: %v", name, version, context.Cause(ctx))}
+				continue
+			}
+			wg.Add(1)
+			go func(i int, in In) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				out, err := Invoke[In, Out](ctx, server, name, version, in)
+				results <- Result[Out]{Index: i, Output: out, Err: err}
+				// Cancel only after sending, so the failure precedes the calls it cancels
+				if err != nil && opts.Mode == FailFast {
+					cancel(errBatchFailed)
+				}
+			}(i, in)
+		}
+		wg.Wait()
+	}()
+	return results
+}
+
+// InvokeAll runs InvokeBatch and returns the outputs in input order. With FailFast the
+// error is the first failure; with CollectErrors it joins every failure, each prefixed
+// with its input index, while the outputs of successful calls are still returned.
+func InvokeAll[In, Out any](ctx context.Context, server, name, version string, inputs []In, opts BatchOptions) ([]Out, error) {
+	outputs := make([]Out, len(inputs))
+	var failures []error
+	var first error
+	for result := range InvokeBatch[In, Out](ctx, server, name, version, inputs, opts) {
+		if result.Err == nil {
+			outputs[result.Index] = result.Output
+			continue
+		}
+		if first == nil {
+			first = result.Err
+		}
+		failures = append(failures, fmt.Errorf("input %d: %w", result.Index, result.Err))
+	}
+	if len(failures) == 0 {
+		return outputs, nil
+	}
+	if opts.Mode == FailFast {
+		return outputs, first
+	}
+	return outputs, errors.Join(failures...)
+}
@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"reflect"
+)
+
+// FlowStatus is a status message sent by a flow started with InvokeFlow while it runs
+type FlowStatus struct {
+	Node    string          // node of the sub-workflow reporting the status
+	Run     string          // run of the sub-workflow
+	Text    string          // status text
+	Payload json.RawMessage // optional status payload, nil when absent
+}
+
+// FlowOption configures a call to InvokeFlow
+type FlowOption func(*flowOptions)
+
+type flowOptions struct {
+	onStatus func(FlowStatus)
+}
+
+// WithFlowStatus has InvokeFlow call fn with every status message of the sub-workflow
+// while it runs. fn runs on the calling goroutine, in the order the messages arrived.
+func WithFlowStatus(fn func(FlowStatus)) FlowOption {
+	return func(o *flowOptions) { o.onStatus = fn }
+}
+
+// InvokeFlow starts workflow workflowID as a sub-workflow with inputs encoded as JSON,
+// along with the JSON Schema of In, and decodes the outputs of the run into Out. Like
+// Invoke it needs a context from NewContext, waits until the flow answers or ctx ends
+// and returns *types.Error values.
+func InvokeFlow[In, Out any](ctx context.Context, workflowID string, inputs In, opts ...FlowOption) (Out, error) {
+	var out Out
+	client, event, ok := FromContext(ctx)
+	if !ok {
+		return out, types.NewError(types.ErrorCodeInternal, ErrNoClient.Error())
+	}
+	var options flowOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	payload, err := json.Marshal(inputs)
+	if err != nil {
+		return out, types.Errorf(types.ErrorCodeInvalidInput, "rpc: cannot encode inputs for flow %s: %v", workflowID, err)
+	}
+	schema := basefunction.GenerateJSONSchema(reflect.TypeFor[In](), basefunction.SchemaOptions{})
+
+	var onStatus func(types.EventMessage)
+	if options.onStatus != nil {
+		onStatus = func(msg types.EventMessage) {
+			if msg.Event != types.EventStatusMessage {
+				return
+			}
+			status := FlowStatus{Node: msg.Node, Run: msg.Run, Text: msg.Text}
+			if msg.Payload != nil {
+				status.Payload = json.RawMessage(*msg.Payload)
+			}
+			options.onStatus(status)
+		}
+	}
+	response, err := client.InvokeFlowRaw(ctx, event, workflowID, schema, payload, onStatus)
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal(response, &out); err != nil {
+		return out, types.Errorf(types.ErrorCodeInternal, "rpc: cannot decode outputs of flow %s: %v", workflowID, err)
+	}
+	return out, nil
+}
+
+// InvokeFlowRaw sends a flow_node_request starting workflowID with a JSON payload on
+// behalf of the event being handled, which may be nil, and returns the raw outputs.
+// schema describes the payload and may be nil. onStatus, when set, receives the
+// status messages the workflow server relays from the sub-workflow, and any other
+// event sent under the request's correlation ID before the response. Errors are
+// classified like Invoke's.
+func (r *RpcClient) InvokeFlowRaw(ctx context.Context, event *types.EventMessage, workflowID string, schema *basefunction.JSONSchema, payload []byte, onStatus func(types.EventMessage)) ([]byte, error) {
+	meta := map[string]any{
+		types.MetaFlow:     workflowID,
+		types.MetaDeadline: deadlineMeta(ctx),
+	}
+	if schema != nil {
+		// Meta only holds plain JSON values
+		encoded, err := json.Marshal(schema)
+		if err != nil {
+			return nil, types.Errorf(types.ErrorCodeInternal, "rpc: cannot encode input schema for flow %s: %v", workflowID, err)
+		}
+		var plain map[string]any
+		if err := json.Unmarshal(encoded, &plain); err == nil {
+			meta[types.MetaInputSchema] = plain
+		}
+	}
+	request := types.EventMessage{
+		Event:   types.EventFlowNodeRequest,
+		Text:    "Invoking flow " + workflowID,
+		Meta:    &meta,
+		Payload: &payload,
+	}
+	if event != nil {
+		request.Server = event.Server
+		request.Node = event.Node
+		request.Workflow = event.Workflow
+		request.Run = event.Run
+		meta["calling_server"] = event.Server
+	}
+
+	response, err := r.roundTrip(ctx, &request, onStatus)
+	if err != nil {
+		return nil, r.classify(err, "flow "+workflowID, "the workflow server")
+	}
+	return response, nil
+}
@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/correlation"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+// ErrNoClient is returned by Invoke when ctx carries no RpcClient
+var ErrNoClient = errors.New("rpc: no client in context; call Invoke from a function handler or use rpc.NewContext")
+
+type contextKey struct{}
+
+// caller is what a handler context knows about the call being handled
+type caller struct {
+	client *RpcClient
+	event  *types.EventMessage
+}
+
+// NewContext returns a context carrying client and the event being handled. Invoke
+// sends its requests through client, in the workflow and run of event. Function
+// handlers receive such a context; event may be nil elsewhere.
+func NewContext(ctx context.Context, client *RpcClient, event *types.EventMessage) context.Context {
+	return context.WithValue(ctx, contextKey{}, caller{client: client, event: event})
+}
+
+// FromContext returns the client and event stored by NewContext
+func FromContext(ctx context.Context) (*RpcClient, *types.EventMessage, bool) {
+	c, ok := ctx.Value(contextKey{}).(caller)
+	if !ok || c.client == nil {
+		return nil, nil, false
+	}
+	return c.client, c.event, true
+}
+
+// Invoke calls function name at version on server with in encoded as JSON and decodes
+// the response into Out. It waits until the response arrives or ctx ends; the deadline
+// of ctx is sent along so the callee stops once the caller gives up. Every failure is
+// a *types.Error: remote errors keep the callee's code, local ones are classified.
+func Invoke[In, Out any](ctx context.Context, server, name, version string, in In) (Out, error) {
+	var out Out
+	client, event, ok := FromContext(ctx)
+	if !ok {
+		return out, types.NewError(types.ErrorCodeInternal, ErrNoClient.Error())
+	}
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return out, types.Errorf(types.ErrorCodeInvalidInput, "rpc: cannot encode input for %s@%s: %v", name, version, err)
+	}
+	response, err := client.InvokeRaw(ctx, event, server, name, version, payload)
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal(response, &out); err != nil {
+		return out, types.Errorf(types.ErrorCodeInternal, "rpc: cannot decode response of %s@%s: %v", name, version, err)
+	}
+	return out, nil
+}
+
+// InvokeRaw calls function name at version on server with a JSON payload on behalf of
+// the event being handled, which may be nil, and returns the raw response payload.
+// Errors are classified like Invoke's.
+func (r *RpcClient) InvokeRaw(ctx context.Context, event *types.EventMessage, server, name, version string, payload []byte) ([]byte, error) {
+	request := functionRequest(ctx, event, server, name, version, payload)
+	response, err := r.roundTrip(ctx, &request, nil)
+	if err != nil {
+		return nil, r.classify(err, name+"@"+version+" on "+server, server)
+	}
+	return response, nil
+}
+
+// functionRequest builds the function_request for a call made on behalf of event
+func functionRequest(ctx context.Context, event *types.EventMessage, server, name, version string, payload []byte) types.EventMessage {
+	request := types.EventMessage{
+		Function: name,
+		Version:  version,
+		Server:   server,
+		Event:    types.EventFunctionRequest,
+		Text:     "Invoking " + name + "@" + version + " on " + server,
+		Meta:     &map[string]any{types.MetaDeadline: deadlineMeta(ctx)},
+		Payload:  &payload,
+	}
+	if event != nil {
+		request.Node = event.Node
+		request.Workflow = event.Workflow
+		request.Run = event.Run
+		(*request.Meta)["calling_server"] = event.Server
+	}
+	return request
+}
+
+// classify turns a failed round trip to target into a *types.Error. Remote errors keep
+// their code; peer names what could not be reached when sending failed.
+func (r *RpcClient) classify(err error, target, peer string) error {
+	if sdkErr := types.AsError(err); sdkErr != nil {
+		return sdkErr
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, correlation.ErrExpired):
+		return types.Errorf(types.ErrorCodeTimeout, "rpc: %s did not answer before the deadline", target)
+	case errors.Is(err, context.Canceled):
+		return types.Errorf(types.ErrorCodeCancelled, "rpc: call to %s was cancelled", target)
+	case r.communicator == nil || errors.Is(err, errSend):
+		return types.Errorf(types.ErrorCodeUnavailable, "rpc: cannot reach %s: %v", peer, err)
+	default:
+		return types.Errorf(types.ErrorCodeInternal, "rpc: call to %s failed: %v", target, err)
+	}
+}
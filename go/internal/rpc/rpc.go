@@ -3,33 +3,43 @@ package rpc
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/correlation"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/models"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
-	"github.com/FatsharkStudiosAB/haja-workers/go/internal/utils"
 	"log"
 	"time"
 )
 
+// errSend marks requests that could not be handed to the communicator
+var errSend = errors.New("failed to send event")
+
+// updateBuffer is the number of status messages and chunks held for a caller that is busy
+const updateBuffer = 256
+
 type RpcClient struct {
 	communicator communication.WorkflowCommunicator
-	router       *correlation.Router
+	registry     *correlation.Registry
 }
 
 func NewRpcClient(workflowOut chan types.EventMessage) *RpcClient {
 	return &RpcClient{
 		communicator: nil, // Legacy constructor - communicator will be nil
-		router:       correlation.NewRouter(),
+		registry:     correlation.NewRegistry(),
 	}
 }
 
-// NewRpcClientWithCommunicator creates a new RPC client with the communication layer
-func NewRpcClientWithCommunicator(communicator communication.WorkflowCommunicator) *RpcClient {
+// NewRpcClientWithCommunicator creates a new RPC client with the communication layer.
+// Responses are matched through registry, or a registry of its own when nil.
+func NewRpcClientWithCommunicator(communicator communication.WorkflowCommunicator, registry *correlation.Registry) *RpcClient {
+	if registry == nil {
+		registry = correlation.NewRegistry()
+	}
 	return &RpcClient{
 		communicator: communicator,
-		router:       correlation.NewRouter(),
+		registry:     registry,
 	}
 }
 
@@ -70,6 +80,8 @@ func (r *RpcClient) SendStatusEvent(eventState *types.EventMessage, text string,
 	return fmt.Errorf("no communication method available")
 }
 
+// Call invokes a function (or flow) and waits for its response. When the callee replies
+// with an error event, the returned error is a *types.Error carrying the remote code.
 func (r *RpcClient) Call(timeoutMinutes int, executionNode *models.Node, eventState *types.EventMessage, payload interface{}) ([]byte, error) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -78,66 +90,104 @@ func (r *RpcClient) Call(timeoutMinutes int, executionNode *models.Node, eventSt
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMinutes)*time.Minute)
 	defer cancel()
 
-	correlationID := utils.UID()
-	responseChan := r.router.Register(correlationID, 1)
-	defer r.router.Remove(correlationID)
-
 	// Create and send the event message
 	var eventMsg types.EventMessage
 	if executionNode.Type != "flow_tool" {
 		eventMsg = types.EventMessage{
-			Function:      executionNode.Data.Function.Name,
-			Version:       executionNode.Data.Function.Version,
-			Server:        executionNode.Data.Function.Server,
-			Node:          executionNode.ID,
-			Workflow:      eventState.Workflow,
-			Run:           eventState.Run,
-			Event:         types.EventFunctionRequest,
-			Text:          "Node " + executionNode.ID + " is invoking a function from a tool server",
-			Meta:          &map[string]interface{}{"calling_server": eventState.Server},
-			Payload:       &payloadBytes,
-			CorrelationID: correlationID,
+			Function: executionNode.Data.Function.Name,
+			Version:  executionNode.Data.Function.Version,
+			Server:   executionNode.Data.Function.Server,
+			Node:     executionNode.ID,
+			Workflow: eventState.Workflow,
+			Run:      eventState.Run,
+			Event:    types.EventFunctionRequest,
+			Text:     "Node " + executionNode.ID + " is invoking a function from a tool server",
+			Meta:     &map[string]interface{}{"calling_server": eventState.Server, types.MetaDeadline: deadlineMeta(ctx)},
+			Payload:  &payloadBytes,
 		}
-		// r.workflowOut <- eventMsg
 	} else {
 		eventMsg = types.EventMessage{
-			Server:        eventState.Server,
-			Node:          executionNode.ID,
-			Workflow:      eventState.Workflow,
-			Run:           eventState.Run,
-			Event:         types.EventFlowNodeRequest,
-			Text:          "Node " + executionNode.ID + " is invoking a flow from a tool server",
-			Meta:          nil,
-			Payload:       &payloadBytes,
-			CorrelationID: correlationID,
+			Server:   eventState.Server,
+			Node:     executionNode.ID,
+			Workflow: eventState.Workflow,
+			Run:      eventState.Run,
+			Event:    types.EventFlowNodeRequest,
+			Text:     "Node " + executionNode.ID + " is invoking a flow from a tool server",
+			Meta:     nil,
+			Payload:  &payloadBytes,
 		}
 	}
+	return r.roundTrip(ctx, &eventMsg, nil)
+}
+
+// roundTrip sends a request under a new correlation ID and waits for its response
+// until ctx ends. An error event is returned as a *types.Error. When onUpdate is set,
+// it is called with the status messages and chunks sent under the same correlation ID
+// meanwhile.
+func (r *RpcClient) roundTrip(ctx context.Context, eventMsg *types.EventMessage, onUpdate func(types.EventMessage)) ([]byte, error) {
+	response, err := r.exchange(ctx, eventMsg, onUpdate)
+	if err != nil {
+		return nil, err
+	}
+	if response.Payload == nil {
+		return nil, fmt.Errorf("received empty payload")
+	}
+	return *response.Payload, nil
+}
+
+// exchange does the work of roundTrip and returns the whole response event
+func (r *RpcClient) exchange(ctx context.Context, eventMsg *types.EventMessage, onUpdate func(types.EventMessage)) (types.EventMessage, error) {
+	correlationID, responseChan := r.registry.Reserve(eventMsg.Event, correlation.DeadlineOf(ctx, 0))
+	defer r.registry.Remove(correlationID)
+	eventMsg.CorrelationID = correlationID
+	var updates chan types.EventMessage
+	if onUpdate != nil {
+		updates = make(chan types.EventMessage, updateBuffer)
+		r.registry.Subscribe(correlationID, updates)
+	}
+
 	if r.communicator != nil {
-		if err := r.communicator.SendEvent(&eventMsg); err != nil {
-			return nil, fmt.Errorf("failed to send event: %w", err)
+		if err := r.communicator.SendEvent(eventMsg); err != nil {
+			return types.EventMessage{}, fmt.Errorf("%w: %w", errSend, err)
 		}
 	} else {
-		return nil, fmt.Errorf("no communication method available")
+		return types.EventMessage{}, fmt.Errorf("no communication method available")
 	}
 
 	// Wait for response with timeout
-	select {
-	case response := <-responseChan:
-		log.Println("RpcClient: Received function response for correlation ID: " + correlationID)
-		if response.Payload == nil {
-			return nil, fmt.Errorf("received empty payload")
-		}
-		return *response.Payload, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	var response types.EventMessage
+	var err error
+	if updates != nil {
+		response, err = r.registry.AwaitUpdates(ctx, responseChan, updates, onUpdate)
+	} else {
+		response, err = r.registry.Await(ctx, responseChan)
+	}
+	if err != nil {
+		return types.EventMessage{}, err
+	}
+	if response.Event == types.EventError {
+		log.Println("RpcClient: Received error response for correlation ID: " + correlationID)
+		return types.EventMessage{}, types.ErrorFromEvent(&response)
 	}
+	log.Println("RpcClient: Received function response for correlation ID: " + correlationID)
+	return response, nil
 }
 
 // ... rest of the code remains the same ...
 func (r *RpcClient) HandleCallResponse(response types.EventMessage) {
-	if response.Event != types.EventFunctionResponse {
+	if response.Event != types.EventFunctionResponse && response.Event != types.EventError {
 		return
 	}
 
-	r.router.Deliver(response.CorrelationID, response)
+	r.registry.Deliver(response)
+}
+
+// deadlineMeta formats the context deadline for the deadline meta key so the callee
+// can stop working once the caller has given up
+func deadlineMeta(ctx context.Context) string {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ""
+	}
+	return deadline.UTC().Format(time.RFC3339Nano)
 }
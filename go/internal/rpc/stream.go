@@ -0,0 +1,172 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"sync"
+)
+
+// ErrStreamClosed is returned when writing a chunk after the stream has ended
+var ErrStreamClosed = errors.New("rpc: chunk stream is closed")
+
+// ChunkWriter sends the output of a streaming function as function_chunk events under
+// the correlation ID of the request being handled, numbered from 0. Close ends the
+// stream with a final chunk, before the function's response is sent.
+type ChunkWriter struct {
+	client *RpcClient
+	event  *types.EventMessage
+
+	mu       sync.Mutex
+	sequence int
+	closed   bool
+}
+
+// NewChunkWriter returns a writer for the request carried by ctx, which comes from
+// NewContext. Without one, writing fails with ErrNoClient.
+func NewChunkWriter(ctx context.Context) *ChunkWriter {
+	client, event, _ := FromContext(ctx)
+	return &ChunkWriter{client: client, event: event}
+}
+
+// Write sends payload as the next chunk. Chunks are sent in the order of the calls,
+// which may come from several goroutines.
+func (w *ChunkWriter) Write(payload []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrStreamClosed
+	}
+	if err := w.send(types.Chunk{Sequence: w.sequence}, &payload); err != nil {
+		return err
+	}
+	w.sequence++
+	return nil
+}
+
+// Close sends the final chunk ending the stream. Later calls do nothing.
+func (w *ChunkWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.send(types.Chunk{Sequence: w.sequence, Final: true}, nil)
+}
+
+// send emits one function_chunk event; callers hold w.mu
+func (w *ChunkWriter) send(chunk types.Chunk, payload *[]byte) error {
+	if w.client == nil || w.event == nil {
+		return ErrNoClient
+	}
+	if w.client.communicator == nil {
+		return fmt.Errorf("no communication method available")
+	}
+	meta := chunk.ToMeta()
+	return w.client.communicator.SendEvent(&types.EventMessage{
+		Function:      w.event.Function,
+		Version:       w.event.Version,
+		Node:          w.event.Node,
+		Workflow:      w.event.Workflow,
+		Run:           w.event.Run,
+		Event:         types.EventFunctionChunk,
+		Meta:          &meta,
+		Payload:       payload,
+		CorrelationID: w.event.CorrelationID,
+	})
+}
+
+// InvokeStream calls a streaming function like Invoke and passes every chunk it emits,
+// decoded into Chunk, to onChunk before returning the function's output. onChunk runs
+// on the calling goroutine, in sequence order.
+func InvokeStream[In, Chunk, Out any](ctx context.Context, server, name, version string, in In, onChunk func(Chunk)) (Out, error) {
+	var out Out
+	client, event, ok := FromContext(ctx)
+	if !ok {
+		return out, types.NewError(types.ErrorCodeInternal, ErrNoClient.Error())
+	}
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return out, types.Errorf(types.ErrorCodeInvalidInput, "rpc: cannot encode input for %s@%s: %v", name, version, err)
+	}
+	var decodeErr error
+	response, err := client.InvokeStreamRaw(ctx, event, server, name, version, payload, func(sequence int, data []byte) {
+		if decodeErr != nil {
+			return
+		}
+		var chunk Chunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			decodeErr = types.Errorf(types.ErrorCodeInternal, "rpc: cannot decode chunk %d of %s@%s: %v", sequence, name, version, err)
+			return
+		}
+		onChunk(chunk)
+	})
+	if err != nil {
+		return out, err
+	}
+	if decodeErr != nil {
+		return out, decodeErr
+	}
+	if err := json.Unmarshal(response, &out); err != nil {
+		return out, types.Errorf(types.ErrorCodeInternal, "rpc: cannot decode response of %s@%s: %v", name, version, err)
+	}
+	return out, nil
+}
+
+// InvokeStreamRaw calls function name at version on server like InvokeRaw and passes
+// the payload of every chunk the function emits to onChunk with its sequence number,
+// in order, before returning the raw response. Chunks lost because the caller did not
+// keep up, or a stream that ends without its final chunk, fail the call. The final chunk
+// is expected once a chunk arrived or the response says the function streams.
+func (r *RpcClient) InvokeStreamRaw(ctx context.Context, event *types.EventMessage, server, name, version string, payload []byte, onChunk func(sequence int, payload []byte)) ([]byte, error) {
+	target := name + "@" + version + " on " + server
+	next, final := 0, false
+	var streamErr error
+	onUpdate := func(msg types.EventMessage) {
+		if msg.Event != types.EventFunctionChunk || streamErr != nil || final {
+			return
+		}
+		chunk, ok := types.ChunkFromMeta(msg.Meta)
+		if !ok {
+			streamErr = types.Errorf(types.ErrorCodeInternal, "rpc: %s sent a chunk without a sequence number", target)
+			return
+		}
+		if chunk.Sequence != next {
+			streamErr = types.Errorf(types.ErrorCodeInternal, "rpc: lost chunks %d to %d of %s", next, chunk.Sequence-1, target)
+			return
+		}
+		if chunk.Final {
+			final = true
+			return
+		}
+		next++
+		var data []byte
+		if msg.Payload != nil {
+			data = *msg.Payload
+		}
+		onChunk(chunk.Sequence, data)
+	}
+
+	request := functionRequest(ctx, event, server, name, version, payload)
+	response, err := r.exchange(ctx, &request, onUpdate)
+	if err != nil {
+		return nil, r.classify(err, target, server)
+	}
+	var streamed bool
+	if response.Meta != nil {
+		streamed, _ = (*response.Meta)[types.MetaStreamed].(bool)
+	}
+	if streamErr == nil && (next > 0 || streamed) && !final {
+		streamErr = types.Errorf(types.ErrorCodeInternal, "rpc: lost the end of the chunk stream of %s after %d chunks", target, next)
+	}
+	if streamErr != nil {
+		return nil, streamErr
+	}
+	if response.Payload == nil {
+		return nil, r.classify(fmt.Errorf("received empty payload"), target, server)
+	}
+	return *response.Payload, nil
+}
@@ -1,13 +1,18 @@
 package state
 
 import (
+	"context"
 	"fmt"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/correlation"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/grpccache"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/grpcstore"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/maps"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/rpc"
 	"os"
+	"sync/atomic"
+	"time"
 )
 
 // CommunicationConfig holds configuration for communication setup
@@ -18,6 +23,23 @@ type CommunicationConfig struct {
 	IncomingBuffer         int
 	ReconnectIntervalSec   int
 	HealthcheckIntervalSec int
+	TLS                    communication.TLSConfig
+	// ReconnectMaxIntervalSec and ReconnectMultiplier shape the reconnection backoff
+	ReconnectMaxIntervalSec int
+	ReconnectMultiplier     float64
+	// Outbound configures the events held while disconnected
+	Outbound communication.OutboundQueueConfig
+	// TokenSource, when set, supplies the API token instead of ServerApiToken
+	TokenSource communication.TokenSource
+
+	// Communicator, when set, is used as is instead of dialing the gRPC server
+	Communicator communication.WorkflowCommunicator
+
+	// Prepare, when set, runs once the state is built and before the gRPC connection is
+	// started, so the first client_registration already reflects what it sets up
+	Prepare func(gs *GlobalState)
+	// RegistrationMeta, when set, supplies the meta of every client_registration
+	RegistrationMeta func(gs *GlobalState) map[string]any
 }
 
 // NewGlobalStateWithMode creates a GlobalState with the specified communication mode
@@ -27,34 +49,58 @@ func NewGlobalStateWithMode(config CommunicationConfig) (*GlobalState, error) {
 		Functions:        maps.NewSafeFunctionMap[string, basefunction.FunctionInterface](),
 		ResponseHandlers: maps.NewSafeFunctionMap[string, chan *[]byte](),
 		ExecutionState:   maps.NewSafeFunctionMap[string, any](),
-	}
+		Executions:       maps.NewSafeFunctionMap[string, context.CancelCauseFunc](),
 
-	var err error
-	var workflowComm communication.WorkflowCommunicator
+		CancelledRequests: maps.NewSafeFunctionMap[string, time.Time](),
 
-	// gRPC is the only supported communication mode
-	workflowComm, err = setupGrpcMode(gs, config)
+		FunctionPanics:    maps.NewSafeFunctionMap[string, *atomic.Int64](),
+		DisabledFunctions: maps.NewSafeFunctionMap[string, string](),
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to setup gRPC communication: %w", err)
+	workflowComm := config.Communicator
+	var grpcCommunicator *communication.GrpcCommunicator
+	if workflowComm == nil {
+		// gRPC is the only supported network communication mode
+		var err error
+		grpcCommunicator, err = setupGrpcMode(gs, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup gRPC communication: %w", err)
+		}
+		workflowComm = grpcCommunicator
+	}
+	if config.RegistrationMeta != nil {
+		if comm, ok := workflowComm.(interface {
+			SetRegistrationMeta(func() map[string]any)
+		}); ok {
+			comm.SetRegistrationMeta(func() map[string]any { return config.RegistrationMeta(gs) })
+		}
 	}
 
 	gs.WorkflowComm = workflowComm
-
-	// RPC client will be set up separately to avoid import cycles
-	gs.RpcClient = nil
+	gs.Correlations = correlation.NewRegistry()
+	gs.RpcClient = rpc.NewRpcClientWithCommunicator(workflowComm, gs.Correlations)
 
 	// Initialize gRPC cache client when a communicator exists
 	if gs.WorkflowComm != nil {
-		gs.GrpcCache = grpccache.NewClient(gs.WorkflowComm, config.ServerName)
-		gs.GrpcStore = grpcstore.NewClient(gs.WorkflowComm, config.ServerName)
+		gs.GrpcCache = grpccache.NewClient(gs.WorkflowComm, config.ServerName, gs.Correlations)
+		gs.GrpcStore = grpcstore.NewClient(gs.WorkflowComm, config.ServerName, gs.Correlations)
+	}
+
+	if config.Prepare != nil {
+		config.Prepare(gs)
+	}
+	if grpcCommunicator != nil {
+		// Connect to gRPC server
+		if err := grpcCommunicator.Connect(); err != nil {
+			return nil, fmt.Errorf("failed to connect to gRPC server: %w", err)
+		}
 	}
 
 	return gs, nil
 }
 
-// setupGrpcMode initializes gRPC-based communication
-func setupGrpcMode(gs *GlobalState, config CommunicationConfig) (communication.WorkflowCommunicator, error) {
+// setupGrpcMode initializes gRPC-based communication; the caller connects it
+func setupGrpcMode(gs *GlobalState, config CommunicationConfig) (*communication.GrpcCommunicator, error) {
 	// Create gRPC communicator with provided options or safe defaults
 	incoming := config.IncomingBuffer
 	if incoming <= 0 {
@@ -77,9 +123,19 @@ func setupGrpcMode(gs *GlobalState, config CommunicationConfig) (communication.W
 		health,
 	)
 
-	// Connect to gRPC server
-	if err := grpcCommunicator.Connect(); err != nil {
-		return nil, fmt.Errorf("failed to connect to gRPC server: %w", err)
+	grpcCommunicator.SetBackoff(communication.Backoff{
+		Initial:    time.Duration(reconn) * time.Second,
+		Max:        time.Duration(config.ReconnectMaxIntervalSec) * time.Second,
+		Multiplier: config.ReconnectMultiplier,
+	})
+	if config.TokenSource != nil {
+		grpcCommunicator.SetTokenSource(config.TokenSource)
+	}
+	if err := grpcCommunicator.SetOutboundQueue(config.Outbound); err != nil {
+		return nil, fmt.Errorf("invalid outbound queue configuration: %w", err)
+	}
+	if err := grpcCommunicator.SetTLSConfig(config.TLS); err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
 	}
 
 	return grpcCommunicator, nil
@@ -1,8 +1,13 @@
 package state
 
 import (
+	"context"
+	"sync/atomic"
+	"time"
+
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/correlation"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/dispatcher"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/grpccache"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/grpcstore"
@@ -18,6 +23,18 @@ type GlobalState struct {
 	ResponseHandlers *maps.SafeFunctionMap[string, chan *[]byte]
 	RpcClient        *rpc.RpcClient
 	ExecutionState   *maps.SafeFunctionMap[string, any]
+	Executions       *maps.SafeFunctionMap[string, context.CancelCauseFunc] // running function executions by correlation ID
 	WorkflowComm     communication.WorkflowCommunicator
 	Dispatcher       *dispatcher.Dispatcher
+	Correlations     *correlation.Registry // pending requests of RpcClient, GrpcCache and GrpcStore
+
+	// CancelledRequests holds cancels that arrived while their request was still queued,
+	// by correlation ID with the time they arrived
+	CancelledRequests *maps.SafeFunctionMap[string, time.Time]
+
+	// Panic tracking: consecutive panics per function key, and functions disabled
+	// after reaching MaxFunctionPanics (0 never disables) with the reason why
+	FunctionPanics    *maps.SafeFunctionMap[string, *atomic.Int64]
+	DisabledFunctions *maps.SafeFunctionMap[string, string]
+	MaxFunctionPanics int
 }
@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/handlers"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/models"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Toolset exposes the functions registered with a worker as LLM tools and runs the
+// tool calls a model makes against them
+type Toolset struct {
+	gs    *state.GlobalState
+	tools map[string]tool
+	names []string
+}
+
+// tool links a tool definition to the key of the function it calls
+type tool struct {
+	functionKey string
+	definition  *models.ToolDefinition
+}
+
+// NewToolset builds a tool for every function currently registered in gs.
+// Tools are named after their function; when several versions of a function are
+// registered, each tool name carries the version as a suffix. Functions whose input
+// is not a JSON object are skipped.
+func NewToolset(gs *state.GlobalState) *Toolset {
+	type candidate struct {
+		key string
+		def basefunction.FunctionDefinition
+	}
+	var candidates []candidate
+	versions := map[string]int{}
+	gs.Functions.Range(func(key string, function basefunction.FunctionInterface) bool {
+		def := function.GetFunctionDefinition()
+		candidates = append(candidates, candidate{key: key, def: def})
+		versions[def.Name]++
+		return true
+	})
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].key < candidates[j].key })
+
+	ts := &Toolset{gs: gs, tools: make(map[string]tool, len(candidates))}
+	for _, c := range candidates {
+		definition, err := models.NewToolDefinition(c.def)
+		if err != nil {
+			log.Printf("Skipping tool for %s: %v", c.key, err)
+			continue
+		}
+		if versions[c.def.Name] > 1 {
+			definition.Function.Name = models.ToolName(c.def.Name + "_" + c.def.Version)
+		}
+		if _, exists := ts.tools[definition.Function.Name]; exists {
+			log.Printf("Skipping tool for %s: name %s is already taken", c.key, definition.Function.Name)
+			continue
+		}
+		ts.tools[definition.Function.Name] = tool{functionKey: c.key, definition: definition}
+		ts.names = append(ts.names, definition.Function.Name)
+	}
+	sort.Strings(ts.names)
+	return ts
+}
+
+// Definitions returns the tool definitions sorted by name
+func (ts *Toolset) Definitions() []models.ToolDefinition {
+	definitions := make([]models.ToolDefinition, 0, len(ts.names))
+	for _, name := range ts.names {
+		definitions = append(definitions, *ts.tools[name].definition)
+	}
+	return definitions
+}
+
+// LLMTools returns the tools in the form expected by llms.WithTools
+func (ts *Toolset) LLMTools() []llms.Tool {
+	tools := make([]llms.Tool, 0, len(ts.names))
+	for _, name := range ts.names {
+		tools = append(tools, *ts.tools[name].definition.ToLLMSTool())
+	}
+	return tools
+}
+
+// Call runs the function behind a tool call. event is the event of the calling node;
+// the function receives a copy addressed to itself. The response content is the
+// function output, or the JSON encoded *types.Error on failure, so it can be handed
+// back to the model either way. The call runs like a request from the workflow
+// server: it shares the function's concurrency limit, its timeout applies and its
+// panics count towards disabling it.
+func (ts *Toolset) Call(ctx context.Context, call llms.ToolCall, event *types.EventMessage) (llms.ToolCallResponse, error) {
+	response := llms.ToolCallResponse{ToolCallID: call.ID}
+	if call.FunctionCall == nil {
+		return failed(response, types.NewError(types.ErrorCodeInvalidInput, "tool call has no function"))
+	}
+	response.Name = call.FunctionCall.Name
+
+	entry, ok := ts.tools[call.FunctionCall.Name]
+	if !ok {
+		return failed(response, types.Errorf(types.ErrorCodeNotFound, "unknown tool %q", call.FunctionCall.Name))
+	}
+	function, ok := ts.gs.Functions.Load(entry.functionKey)
+	if !ok {
+		return failed(response, types.Errorf(types.ErrorCodeUnavailable, "function %s is no longer available", entry.functionKey))
+	}
+
+	arguments := []byte(call.FunctionCall.Arguments)
+	if strings.TrimSpace(call.FunctionCall.Arguments) == "" {
+		arguments = []byte("{}")
+	}
+
+	output, err := handlers.Execute(ctx, ts.gs, function, toolEvent(event, function.GetFunctionDefinition(), &arguments))
+	if err != nil {
+		if sdkErr := types.AsError(err); sdkErr != nil {
+			return failed(response, sdkErr)
+		}
+		return failed(response, types.Errorf(types.ErrorCodeInternal, "tool %s failed: %v", response.Name, err))
+	}
+	response.Content = string(*output)
+	return response, nil
+}
+
+// failed puts the encoded error into the response content and returns both
+func failed(response llms.ToolCallResponse, sdkErr *types.Error) (llms.ToolCallResponse, error) {
+	content, err := json.Marshal(sdkErr)
+	if err != nil {
+		content = []byte(fmt.Sprintf(`{"code":%q,"message":%q}`, sdkErr.Code, sdkErr.Message))
+	}
+	response.Content = string(content)
+	return response, sdkErr
+}
+
+// toolEvent turns a copy of the caller's event into a function request for the called function
+func toolEvent(event *types.EventMessage, def basefunction.FunctionDefinition, arguments *[]byte) *types.EventMessage {
+	var msg types.EventMessage
+	if event != nil {
+		msg = *event
+	}
+	msg.Event = types.EventFunctionRequest
+	msg.Function = def.Name
+	msg.Version = def.Version
+	msg.Payload = arguments
+	return &msg
+}
@@ -0,0 +1,206 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/dispatcher"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/maps"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/tmc/langchaingo/llms"
+)
+
+type weatherQuery struct {
+	City  string         `json:"city" description:"City name" validate:"min=1"`
+	Units string         `json:"units,omitempty" validate:"oneof=metric imperial"`
+	Days  []weatherDay   `json:"days,omitempty"`
+	Near  *weatherDay    `json:"near,omitempty" description:"Day the forecast centres on"`
+	Tags  map[string]int `json:"tags,omitempty"`
+	Notes []any          `json:"notes,omitempty"`
+	Extra any            `json:"extra,omitempty"`
+}
+
+type weatherDay struct {
+	Offset int `json:"offset" validate:"min=0,max=7"`
+}
+
+type weatherReport struct {
+	Summary string `json:"summary"`
+}
+
+func newTestToolset(t *testing.T) *Toolset {
+	t.Helper()
+	gs := &state.GlobalState{
+		ServerName:        "test",
+		Functions:         maps.NewSafeFunctionMap[string, basefunction.FunctionInterface](),
+		FunctionPanics:    maps.NewSafeFunctionMap[string, *atomic.Int64](),
+		DisabledFunctions: maps.NewSafeFunctionMap[string, string](),
+	}
+	weather := basefunction.NewFunction[weatherQuery, weatherReport]("weather.lookup", "1.0.0", "Look up the weather",
+		func(in weatherQuery, event *types.EventMessage) (weatherReport, error) {
+			return weatherReport{Summary: in.City + " via " + event.Run}, nil
+		}, nil)
+	scalar := basefunction.NewFunction[string, string]("echo", "1.0.0", "",
+		func(in string, _ *types.EventMessage) (string, error) { return in, nil }, nil)
+	gs.Functions.Store(types.FunctionKey("test", weather.Name, weather.Version), weather)
+	gs.Functions.Store(types.FunctionKey("test", scalar.Name, scalar.Version), scalar)
+	return NewToolset(gs)
+}
+
+func TestToolsetDefinitions(t *testing.T) {
+	definitions := newTestToolset(t).Definitions()
+	if len(definitions) != 1 {
+		t.Fatalf("expected only the object-input function as a tool, got %d", len(definitions))
+	}
+
+	spec := definitions[0].Function
+	if spec.Name != "weather_lookup" || spec.Description != "Look up the weather" {
+		t.Fatalf("unexpected spec: %+v", spec)
+	}
+	params := spec.Parameters
+	if params.Type != "object" || len(params.Required) != 1 || params.Required[0] != "city" {
+		t.Fatalf("unexpected parameters: %+v", params)
+	}
+	if city := params.Properties["city"]; city.Type != "string" || city.Description != "City name" {
+		t.Fatalf("unexpected city property: %+v", city)
+	}
+	if units := params.Properties["units"]; len(units.Enum) != 2 {
+		t.Fatalf("expected enum on units, got %+v", units)
+	}
+	days := params.Properties["days"]
+	if days.Type != "array" || days.Items == nil || days.Items.Properties["offset"].Type != "integer" {
+		t.Fatalf("expected nested item properties, got %+v", days)
+	}
+	// The field's description survives inlining the referenced type
+	if near := params.Properties["near"]; near.Description != "Day the forecast centres on" || near.Properties["offset"].Type != "integer" {
+		t.Fatalf("expected the field description on the inlined type, got %+v", near)
+	}
+	if tags := params.Properties["tags"]; tags.Type != "object" || tags.AdditionalProperties == nil || tags.AdditionalProperties.Type != "integer" {
+		t.Fatalf("expected the map value type, got %+v", tags)
+	}
+	// Untyped values accept anything instead of carrying an invalid empty type
+	encoded, _ := json.Marshal(params)
+	if strings.Contains(string(encoded), `"type":""`) {
+		t.Fatalf("expected no empty types, got %s", encoded)
+	}
+	if !strings.Contains(string(encoded), `"extra":{}`) || !strings.Contains(string(encoded), `"notes":{"type":"array","items":{}}`) {
+		t.Fatalf("expected untyped values to accept anything, got %s", encoded)
+	}
+}
+
+func TestToolsetCall(t *testing.T) {
+	ts := newTestToolset(t)
+	event := &types.EventMessage{Run: "run-1"}
+
+	response, err := ts.Call(context.Background(), llms.ToolCall{
+		ID:           "call-1",
+		FunctionCall: &llms.FunctionCall{Name: "weather_lookup", Arguments: `{"city":"Uppsala"}`},
+	}, event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.ToolCallID != "call-1" || response.Content != `{"summary":"Uppsala via run-1"}` {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	response, err = ts.Call(context.Background(), llms.ToolCall{
+		ID:           "call-2",
+		FunctionCall: &llms.FunctionCall{Name: "weather_lookup", Arguments: `{"city":""}`},
+	}, event)
+	if types.ErrorCodeOf(err) != types.ErrorCodeInvalidInput {
+		t.Fatalf("expected invalid_input, got %v", err)
+	}
+	var content types.Error
+	if json.Unmarshal([]byte(response.Content), &content) != nil || content.Code != types.ErrorCodeInvalidInput {
+		t.Fatalf("expected the error in the response content, got %q", response.Content)
+	}
+
+	if _, err := ts.Call(context.Background(), llms.ToolCall{FunctionCall: &llms.FunctionCall{Name: "missing"}}, event); types.ErrorCodeOf(err) != types.ErrorCodeNotFound {
+		t.Fatalf("expected not_found, got %v", err)
+	}
+}
+
+func TestToolsetCallRunsLikeAFunctionRequest(t *testing.T) {
+	gs := &state.GlobalState{
+		ServerName:        "test",
+		WorkflowComm:      communication.NewMemoryCommunicator(10),
+		Dispatcher:        dispatcher.NewDispatcher(10),
+		Functions:         maps.NewSafeFunctionMap[string, basefunction.FunctionInterface](),
+		FunctionPanics:    maps.NewSafeFunctionMap[string, *atomic.Int64](),
+		DisabledFunctions: maps.NewSafeFunctionMap[string, string](),
+		MaxFunctionPanics: 2,
+	}
+	gs.Dispatcher.SetLimitFunc(func(msg *types.EventMessage) (string, int) {
+		if function, ok := gs.Functions.Load(types.FunctionKey(gs.ServerName, msg.Function, msg.Version)); ok {
+			return msg.Function, function.(interface{ GetMaxConcurrency() int }).GetMaxConcurrency()
+		}
+		return msg.Function, 0
+	})
+	var running atomic.Int32
+	release := make(chan struct{})
+	limited := basefunction.NewFunction[weatherQuery, weatherReport]("limited", "1.0.0", "",
+		func(in weatherQuery, _ *types.EventMessage) (weatherReport, error) {
+			running.Add(1)
+			defer running.Add(-1)
+			<-release
+			return weatherReport{Summary: in.City}, nil
+		}, nil)
+	limited.SetMaxConcurrency(1)
+	slow := basefunction.NewFunctionWithContext[weatherQuery, weatherReport]("slow", "1.0.0", "",
+		func(ctx context.Context, _ weatherQuery, _ *types.EventMessage) (weatherReport, error) {
+			<-ctx.Done()
+			return weatherReport{}, ctx.Err()
+		}, nil)
+	slow.SetTimeout(20 * time.Millisecond)
+	broken := basefunction.NewFunction[weatherQuery, weatherReport]("broken", "1.0.0", "",
+		func(weatherQuery, *types.EventMessage) (weatherReport, error) { panic("boom") }, nil)
+	for _, function := range []*basefunction.Function[weatherQuery, weatherReport]{limited, slow, broken} {
+		gs.Functions.Store(types.FunctionKey(gs.ServerName, function.Name, function.Version), function)
+	}
+	ts := NewToolset(gs)
+	call := func(ctx context.Context, name string) error {
+		_, err := ts.Call(ctx, llms.ToolCall{FunctionCall: &llms.FunctionCall{Name: name, Arguments: `{"city":"Uppsala"}`}}, nil)
+		return err
+	}
+
+	// A second call waits for the only slot of the function
+	first := make(chan error, 1)
+	go func() { first <- call(context.Background(), "limited") }()
+	for running.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := call(ctx, "limited"); types.ErrorCodeOf(err) != types.ErrorCodeCancelled {
+		t.Fatalf("expected the call waiting for a slot to be cancelled, got %v", err)
+	}
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The function's timeout applies
+	if err := call(context.Background(), "slow"); types.ErrorCodeOf(err) != types.ErrorCodeTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// Panics count towards disabling the function
+	for i := 0; i < 2; i++ {
+		if err := call(context.Background(), "broken"); types.ErrorCodeOf(err) != types.ErrorCodeInternal {
+			t.Fatalf("expected an internal error, got %v", err)
+		}
+	}
+	if _, disabled := gs.DisabledFunctions.Load(types.FunctionKey(gs.ServerName, "broken", "1.0.0")); !disabled {
+		t.Fatal("expected the panicking function to be disabled")
+	}
+	if err := call(context.Background(), "broken"); types.ErrorCodeOf(err) != types.ErrorCodeUnavailable {
+		t.Fatalf("expected the disabled function to be unavailable, got %v", err)
+	}
+}
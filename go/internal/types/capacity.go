@@ -0,0 +1,64 @@
+package types
+
+// Capacity describes how much work a worker can take, so the workflow server can route
+// function requests to the least-loaded replica of a server name
+type Capacity struct {
+	HandlersConcurrency int `json:"handlers_concurrency"`
+	InFlight            int `json:"in_flight"`
+	QueueDepth          int `json:"queue_depth"`
+	QueueCapacity       int `json:"queue_capacity"`
+	// FunctionConcurrency maps "name:version" to the function's concurrency limit;
+	// functions without a limit are omitted
+	FunctionConcurrency map[string]int `json:"function_concurrency,omitempty"`
+}
+
+// ToMeta returns the capacity as carried under MetaCapacity
+func (c Capacity) ToMeta() map[string]any {
+	meta := map[string]any{
+		"handlers_concurrency": c.HandlersConcurrency,
+		"in_flight":            c.InFlight,
+		"queue_depth":          c.QueueDepth,
+		"queue_capacity":       c.QueueCapacity,
+	}
+	if len(c.FunctionConcurrency) > 0 {
+		functions := make(map[string]any, len(c.FunctionConcurrency))
+		for key, limit := range c.FunctionConcurrency {
+			functions[key] = limit
+		}
+		meta["function_concurrency"] = functions
+	}
+	return meta
+}
+
+// CapacityFromMeta reads the capacity under MetaCapacity of an event's meta
+func CapacityFromMeta(meta *map[string]any) (Capacity, bool) {
+	if meta == nil {
+		return Capacity{}, false
+	}
+	values, ok := (*meta)[MetaCapacity].(map[string]any)
+	if !ok {
+		return Capacity{}, false
+	}
+	number := func(v any) int {
+		switch n := v.(type) {
+		case float64:
+			return int(n)
+		case int:
+			return n
+		}
+		return 0
+	}
+	c := Capacity{
+		HandlersConcurrency: number(values["handlers_concurrency"]),
+		InFlight:            number(values["in_flight"]),
+		QueueDepth:          number(values["queue_depth"]),
+		QueueCapacity:       number(values["queue_capacity"]),
+	}
+	if functions, ok := values["function_concurrency"].(map[string]any); ok && len(functions) > 0 {
+		c.FunctionConcurrency = make(map[string]int, len(functions))
+		for key, limit := range functions {
+			c.FunctionConcurrency[key] = number(limit)
+		}
+	}
+	return c, true
+}
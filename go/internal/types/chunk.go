@@ -0,0 +1,33 @@
+package types
+
+// Chunk is the position of a function_chunk event in the stream of its request
+type Chunk struct {
+	Sequence int  `json:"sequence"`
+	Final    bool `json:"final"`
+}
+
+// ToMeta returns the chunk position as carried under MetaSequence and MetaFinal
+func (c Chunk) ToMeta() map[string]any {
+	return map[string]any{
+		MetaSequence: c.Sequence,
+		MetaFinal:    c.Final,
+	}
+}
+
+// ChunkFromMeta reads the chunk position of a function_chunk event's meta
+func ChunkFromMeta(meta *map[string]any) (Chunk, bool) {
+	if meta == nil {
+		return Chunk{}, false
+	}
+	var c Chunk
+	switch n := (*meta)[MetaSequence].(type) {
+	case float64:
+		c.Sequence = int(n)
+	case int:
+		c.Sequence = n
+	default:
+		return Chunk{}, false
+	}
+	c.Final, _ = (*meta)[MetaFinal].(bool)
+	return c, true
+}
@@ -3,3 +3,35 @@ package types
 const (
 	FUNCTION_PREFIX = "function:"
 )
+
+// Meta keys with a shared meaning across events
+const (
+	// MetaDeadline carries the caller's deadline for a request, either as an
+	// RFC 3339 timestamp or as Unix time in milliseconds
+	MetaDeadline = "deadline"
+
+	// MetaErrorCode carries the machine-readable code of an error event
+	MetaErrorCode = "error_code"
+
+	// MetaRetryable carries whether the request that failed may be retried
+	MetaRetryable = "retryable"
+
+	// MetaCapacity carries a worker's Capacity on registrations, heartbeats and server info
+	MetaCapacity = "capacity"
+
+	// MetaFlow carries the ID of the workflow a flow_node_request starts
+	MetaFlow = "flow"
+
+	// MetaInputSchema carries the JSON Schema of the inputs sent to a flow
+	MetaInputSchema = "input_schema"
+
+	// MetaSequence carries the position of a function_chunk in its stream, from 0
+	MetaSequence = "sequence"
+
+	// MetaFinal marks the function_chunk that ends a stream; it carries no payload
+	MetaFinal = "final"
+
+	// MetaStreamed marks the function_response of a streaming function, whose chunks
+	// always end with the final one
+	MetaStreamed = "streamed"
+)
@@ -0,0 +1,131 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrorCode classifies an error so callers can decide how to react to it.
+// Codes are sent over the wire and must stay identical across languages.
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidInput ErrorCode = "invalid_input"
+	ErrorCodeNotFound     ErrorCode = "not_found"
+	ErrorCodeTimeout      ErrorCode = "timeout"
+	ErrorCodeInternal     ErrorCode = "internal"
+	ErrorCodeUnavailable  ErrorCode = "unavailable"
+	ErrorCodeCancelled    ErrorCode = "cancelled"
+	// ErrorCodeOverloaded means the worker refused the request because it is at capacity;
+	// the request can be retried on another replica
+	ErrorCodeOverloaded ErrorCode = "overloaded"
+)
+
+// Error is a structured error carried by error events.
+// The code and retryable flag are sent in Meta, the whole error as JSON in Payload.
+type Error struct {
+	Code      ErrorCode       `json:"code"`
+	Message   string          `json:"message"`
+	Retryable bool            `json:"retryable"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
+
+// NewError creates an error with the given code. Timeouts, unavailability and overload are retryable by default.
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{
+		Code:      code,
+		Message:   message,
+		Retryable: code == ErrorCodeTimeout || code == ErrorCodeUnavailable || code == ErrorCodeOverloaded,
+	}
+}
+
+// Errorf creates an error with the given code and a formatted message
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return NewError(code, fmt.Sprintf(format, args...))
+}
+
+// WithRetryable overrides the default retryable flag
+func (e *Error) WithRetryable(retryable bool) *Error {
+	e.Retryable = retryable
+	return e
+}
+
+// WithDetails attaches a JSON-serialisable details payload. Details that cannot be
+// marshalled are replaced by a string describing the failure.
+func (e *Error) WithDetails(details any) *Error {
+	data, err := json.Marshal(details)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("unserialisable details: %v", err))
+	}
+	e.Details = data
+	return e
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// AsError returns the first *Error in err's chain, or nil if there is none
+func AsError(err error) *Error {
+	var sdkErr *Error
+	if errors.As(err, &sdkErr) {
+		return sdkErr
+	}
+	return nil
+}
+
+// ErrorCodeOf returns the code of the first *Error in err's chain, or
+// ErrorCodeInternal for any other non-nil error
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+	if sdkErr := AsError(err); sdkErr != nil {
+		return sdkErr.Code
+	}
+	return ErrorCodeInternal
+}
+
+// ToEvent fills the Meta and Payload of an error event from the error
+func (e *Error) ToEvent(event *EventMessage) {
+	meta := map[string]any{}
+	if event.Meta != nil {
+		for k, v := range *event.Meta {
+			meta[k] = v
+		}
+	}
+	meta[MetaErrorCode] = string(e.Code)
+	meta[MetaRetryable] = e.Retryable
+	event.Meta = &meta
+
+	if payload, err := json.Marshal(e); err == nil {
+		event.Payload = &payload
+	}
+}
+
+// ErrorFromEvent rebuilds an *Error from an error event. Events sent by peers that
+// predate structured errors are mapped to ErrorCodeInternal with the event text.
+func ErrorFromEvent(event *EventMessage) *Error {
+	if event.Payload != nil {
+		var sdkErr Error
+		if err := json.Unmarshal(*event.Payload, &sdkErr); err == nil && sdkErr.Code != "" {
+			if sdkErr.Message == "" {
+				sdkErr.Message = event.Text
+			}
+			return &sdkErr
+		}
+	}
+
+	sdkErr := NewError(ErrorCodeInternal, event.Text)
+	if event.Meta != nil {
+		if code, ok := (*event.Meta)[MetaErrorCode].(string); ok && code != "" {
+			sdkErr = NewError(ErrorCode(code), event.Text)
+		}
+		if retryable, ok := (*event.Meta)[MetaRetryable].(bool); ok {
+			sdkErr.Retryable = retryable
+		}
+	}
+	return sdkErr
+}
@@ -0,0 +1,58 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestErrorEventRoundTrip(t *testing.T) {
+	original := NewError(ErrorCodeInvalidInput, "name is required").
+		WithDetails(map[string]any{"field": "name"})
+
+	event := EventMessage{Event: EventError, Text: original.Message, CorrelationID: "abc"}
+	original.ToEvent(&event)
+
+	if event.Meta == nil || (*event.Meta)[MetaErrorCode] != "invalid_input" {
+		t.Fatalf("expected error code in meta, got %v", event.Meta)
+	}
+	if (*event.Meta)[MetaRetryable] != false {
+		t.Fatalf("expected retryable=false in meta, got %v", (*event.Meta)[MetaRetryable])
+	}
+
+	rebuilt := ErrorFromEvent(&event)
+	if rebuilt.Code != ErrorCodeInvalidInput || rebuilt.Message != "name is required" || rebuilt.Retryable {
+		t.Fatalf("unexpected rebuilt error: %+v", rebuilt)
+	}
+	var details map[string]string
+	if err := json.Unmarshal(rebuilt.Details, &details); err != nil || details["field"] != "name" {
+		t.Fatalf("unexpected details %s (%v)", rebuilt.Details, err)
+	}
+}
+
+func TestErrorFromLegacyEvent(t *testing.T) {
+	// Peers without structured errors only send text
+	event := EventMessage{Event: EventError, Text: "Function not found"}
+	rebuilt := ErrorFromEvent(&event)
+	if rebuilt.Code != ErrorCodeInternal || rebuilt.Message != "Function not found" {
+		t.Fatalf("unexpected rebuilt error: %+v", rebuilt)
+	}
+
+	// Meta-only events keep their code and retryability
+	meta := map[string]any{MetaErrorCode: "timeout"}
+	event.Meta = &meta
+	rebuilt = ErrorFromEvent(&event)
+	if rebuilt.Code != ErrorCodeTimeout || !rebuilt.Retryable {
+		t.Fatalf("unexpected rebuilt error: %+v", rebuilt)
+	}
+}
+
+func TestErrorCodeOfWrappedError(t *testing.T) {
+	err := fmt.Errorf("handler error: %w", NewError(ErrorCodeUnavailable, "upstream down"))
+	if code := ErrorCodeOf(err); code != ErrorCodeUnavailable {
+		t.Fatalf("expected unavailable, got %q", code)
+	}
+	if code := ErrorCodeOf(fmt.Errorf("plain")); code != ErrorCodeInternal {
+		t.Fatalf("expected internal, got %q", code)
+	}
+}
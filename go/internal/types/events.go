@@ -8,9 +8,20 @@ const (
 	EventStatusMessage      = "status_message"
 	EventClientRegistration = "client_registration"
 
+	// Sent by a worker that is shutting down and will not accept new requests
+	EventClientDeregistration = "client_deregistration"
+
+	// Sent periodically by a worker with its Capacity in Meta
+	EventHeartbeat = "heartbeat"
+
 	// Function invocation
 	EventFunctionRequest  = "function_request"
 	EventFunctionResponse = "function_response"
+	EventFunctionCancel   = "function_cancel"
+
+	// Sent by a streaming function for each chunk of output before its response; Meta
+	// carries the Chunk sequence, and a last chunk marked final ends the stream
+	EventFunctionChunk = "function_chunk"
 
 	// Flow invocation
 	EventFlowNodeRequest = "flow_node_request"
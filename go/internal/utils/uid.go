@@ -3,20 +3,30 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/binary"
-	"fmt"
+	"encoding/hex"
+	"time"
 )
 
-// Generate a random UID in the format "d83a-f68e"
+// UID returns a new UUIDv7 (RFC 9562) in canonical form, e.g.
+// "0190b5e4-3c1a-7d2e-9f40-5b6c7d8e9f01". The first 48 bits are the Unix time in
+// milliseconds, so IDs sort by creation time; 74 of the remaining bits are random.
+// The Python SDK generates the same format.
 func UID() string {
-	// Pre-allocate buffer with exact size needed
-	b := make([]byte, 9)
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	rand.Read(b[6:])
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant
 
-	// Generate 4 random bytes for each part
-	rand.Read(b[:4])
-	rand.Read(b[4:8])
-
-	// Format as hex with dash
-	return fmt.Sprintf("%04x-%04x",
-		binary.BigEndian.Uint32(b[:4])&0xffff,
-		binary.BigEndian.Uint32(b[4:8])&0xffff)
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
 }
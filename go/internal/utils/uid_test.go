@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+var uuidV7 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestUIDIsATimeOrderedUUIDv7(t *testing.T) {
+	first := UID()
+	time.Sleep(2 * time.Millisecond)
+	second := UID()
+	for _, id := range []string{first, second} {
+		if !uuidV7.MatchString(id) {
+			t.Fatalf("%q is not a UUIDv7", id)
+		}
+	}
+	if first >= second {
+		t.Fatalf("expected %q to sort before %q", first, second)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100000; i++ {
+		id := UID()
+		if seen[id] {
+			t.Fatalf("duplicate ID %q", id)
+		}
+		seen[id] = true
+	}
+}
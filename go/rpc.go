@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/rpc"
+)
+
+// Invoke calls a function of another worker, or of this one, and decodes its response.
+// Call it from a context handler with the handler's ctx, which carries the RpcClient
+// and the workflow and run the call belongs to:
+//
+//	sum, err := worker.Invoke[SumInput, SumOutput](ctx, "math-worker", "sum", "1.0.0", SumInput{A: 1, B: 2})
+//
+// The call waits until the response arrives or ctx ends, and sends the deadline of ctx
+// to the callee. Errors are *Error values: remote errors keep the callee's code, a
+// deadline is reported as ErrorCodeTimeout and an unreachable server as ErrorCodeUnavailable.
+func Invoke[In, Out any](ctx context.Context, server, name, version string, in In) (Out, error) {
+	return rpc.Invoke[In, Out](ctx, server, name, version, in)
+}
+
+// BatchMode decides how InvokeBatch and InvokeAll react to a failed call
+type BatchMode = rpc.BatchMode
+
+// Batch modes
+const (
+	// CollectErrors runs every call and reports each failure with its input
+	CollectErrors = rpc.CollectErrors
+	// FailFast cancels the calls in flight and skips the rest after the first failure
+	FailFast = rpc.FailFast
+)
+
+// BatchOptions bounds the calls a batch keeps in flight and sets its BatchMode
+type BatchOptions = rpc.BatchOptions
+
+// DefaultBatchConcurrency is the number of calls a batch keeps in flight by default
+const DefaultBatchConcurrency = rpc.DefaultBatchConcurrency
+
+// InvokeBatch calls a function once per input, keeping up to opts.Concurrency requests
+// in flight, and streams one result per input as the calls complete. Result.Index is the
+// position of the input. The channel is closed after the last result.
+//
+//	for result := range worker.InvokeBatch[Item, Summary](ctx, "llm-worker", "summarize", "1.0.0", items, worker.BatchOptions{Concurrency: 8}) {
+//	    if result.Err != nil { ... }
+//	    summaries[result.Index] = result.Output
+//	}
+func InvokeBatch[In, Out any](ctx context.Context, server, name, version string, inputs []In, opts BatchOptions) <-chan rpc.Result[Out] {
+	return rpc.InvokeBatch[In, Out](ctx, server, name, version, inputs, opts)
+}
+
+// InvokeAll calls a function once per input like InvokeBatch and returns the outputs in
+// input order. With FailFast the error is the first failure; with CollectErrors it joins
+// every failure, and the outputs of the successful calls are returned as well.
+func InvokeAll[In, Out any](ctx context.Context, server, name, version string, inputs []In, opts BatchOptions) ([]Out, error) {
+	return rpc.InvokeAll[In, Out](ctx, server, name, version, inputs, opts)
+}
+
+// FlowStatus is a status message sent by a flow started with InvokeFlow while it runs
+type FlowStatus = rpc.FlowStatus
+
+// FlowOption configures a call to InvokeFlow
+type FlowOption = rpc.FlowOption
+
+// WithFlowStatus has InvokeFlow call fn with every status message of the sub-workflow
+// while it runs, on the calling goroutine and in order
+func WithFlowStatus(fn func(FlowStatus)) FlowOption {
+	return rpc.WithFlowStatus(fn)
+}
+
+// InvokeFlow starts a workflow as a sub-workflow of the one being handled and decodes the
+// outputs of its run. The flow_node_request names the workflow and carries the JSON
+// Schema of In next to the inputs:
+//
+//	report, err := worker.InvokeFlow[ReviewInput, ReviewOutput](ctx, "code-review", input,
+//	    worker.WithFlowStatus(func(s worker.FlowStatus) { log.Println(s.Text) }))
+//
+// Deadlines and errors behave as with Invoke.
+func InvokeFlow[In, Out any](ctx context.Context, workflowID string, inputs In, opts ...FlowOption) (Out, error) {
+	return rpc.InvokeFlow[In, Out](ctx, workflowID, inputs, opts...)
+}
+
+// InvokeStream calls a streaming function like Invoke and passes each chunk it emits to
+// onChunk, in order and on the calling goroutine, before returning its output:
+//
+//	answer, err := worker.InvokeStream[Prompt, Token, Answer](ctx, "llm-worker", "complete", "1.0.0", prompt,
+//	    func(token Token) { fmt.Print(token.Text) })
+//
+// Chunks lost because onChunk did not keep up fail the call with ErrorCodeInternal.
+func InvokeStream[In, Chunk, Out any](ctx context.Context, server, name, version string, in In, onChunk func(Chunk)) (Out, error) {
+	return rpc.InvokeStream[In, Chunk, Out](ctx, server, name, version, in, onChunk)
+}
@@ -1,24 +1,55 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/communication"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/correlation"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/dispatcher"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/handlers"
-	"github.com/FatsharkStudiosAB/haja-workers/go/internal/rpc"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
 	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
 
 	"github.com/joho/godotenv"
 )
 
+// errShuttingDown is the cancellation cause for executions still running when the shutdown deadline is reached
+var errShuttingDown = errors.New("worker is shutting down")
+
+// ErrServerClosed is returned by StartContext after Shutdown has been called
+var ErrServerClosed = errors.New("worker: server closed")
+
+// errAlreadyStarted is returned by StartContext when the server was started before
+var errAlreadyStarted = errors.New("worker: server already started")
+
 // Server represents the SDK server instance
 type Server struct {
 	config      *Config
 	globalState *state.GlobalState
 	functions   []FunctionBuilder
+
+	// Shutdown coordination. mu guards started and closed; ready is closed once
+	// StartContext has finished initializing, so Shutdown never races with it.
+	mu           sync.Mutex
+	started      bool
+	closed       bool
+	ready        chan struct{}
+	done         chan struct{}
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	// running publishes the global state to other goroutines once the server has started
+	running atomic.Pointer[state.GlobalState]
+
+	// heartbeat is cancelled at shutdown to stop the heartbeat loop
+	heartbeat     context.Context
+	stopHeartbeat context.CancelFunc
 }
 
 // New creates a new SDK server instance with the provided options
@@ -34,7 +65,10 @@ func New(opts ...Option) (*Server, error) {
 	server := &Server{
 		config:    config,
 		functions: make([]FunctionBuilder, 0),
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
 	}
+	server.heartbeat, server.stopHeartbeat = context.WithCancel(context.Background())
 
 	return server, nil
 }
@@ -67,12 +101,45 @@ func (s *Server) initializeEnvironment() error {
 func (s *Server) initializeGlobalState() error {
 	// Create communication config from SDK config
 	commConfig := state.CommunicationConfig{
-		ServerName:             s.config.ServerName,
-		GrpcServerAddress:      s.config.GrpcServerAddress,
-		ServerApiToken:         s.config.ServerApiToken,
-		IncomingBuffer:         s.config.IncomingEventsBuffer,
-		ReconnectIntervalSec:   s.config.GrpcReconnectIntervalSec,
-		HealthcheckIntervalSec: s.config.GrpcHealthcheckIntervalSec,
+		ServerName:              s.config.ServerName,
+		GrpcServerAddress:       s.config.GrpcServerAddress,
+		ServerApiToken:          s.config.ServerApiToken,
+		IncomingBuffer:          s.config.IncomingEventsBuffer,
+		ReconnectIntervalSec:    s.config.GrpcReconnectIntervalSec,
+		ReconnectMaxIntervalSec: s.config.GrpcReconnectMaxIntervalSec,
+		ReconnectMultiplier:     s.config.GrpcReconnectMultiplier,
+		HealthcheckIntervalSec:  s.config.GrpcHealthcheckIntervalSec,
+		TLS: communication.TLSConfig{
+			Enabled:    s.config.GrpcTLS,
+			CAFile:     s.config.GrpcTLSCAFile,
+			CertFile:   s.config.GrpcTLSCertFile,
+			KeyFile:    s.config.GrpcTLSKeyFile,
+			ServerName: s.config.GrpcTLSServerName,
+		},
+		Outbound: communication.OutboundQueueConfig{
+			Size:     s.config.OutboundQueueSize,
+			Dir:      s.config.OutboundQueueDir,
+			Policies: s.config.OutboundPolicies,
+		},
+		TokenSource:  s.config.tokenSource(),
+		Communicator: s.config.Communicator,
+		// The dispatcher and functions are in place before connecting, so the first
+		// registration already advertises the worker's capacity
+		Prepare: func(gs *state.GlobalState) {
+			s.globalState = gs
+			gs.MaxFunctionPanics = s.config.MaxFunctionPanics
+
+			// Initialize dispatcher with configured buffer and concurrency
+			gs.Dispatcher = dispatcher.NewDispatcher(s.config.IncomingEventsBuffer)
+			gs.Dispatcher.Start(s.config.HandlersConcurrency)
+
+			// Register functions
+			s.registerAndPublishFunctions()
+		},
+		// Advertise capacity on every (re-)registration
+		RegistrationMeta: func(gs *state.GlobalState) map[string]any {
+			return map[string]any{types.MetaCapacity: handlers.CurrentCapacity(gs).ToMeta()}
+		},
 	}
 
 	globalState, err := state.NewGlobalStateWithMode(commConfig)
@@ -82,13 +149,7 @@ func (s *Server) initializeGlobalState() error {
 
 	// No Redis-based function cache to flush; gRPC cache is used implicitly
 
-	// Set up RPC client after global state is created to avoid import cycles
 	s.globalState = globalState
-	s.globalState.RpcClient = rpc.NewRpcClientWithCommunicator(globalState.WorkflowComm)
-
-	// Initialize dispatcher with configured buffer and concurrency
-	s.globalState.Dispatcher = dispatcher.NewDispatcher(s.config.IncomingEventsBuffer)
-	s.globalState.Dispatcher.Start(s.config.HandlersConcurrency)
 
 	// GrpcCache client is created in state.NewGlobalStateWithMode when a communicator exists
 	// Nothing more to do here
@@ -184,8 +245,46 @@ func (s *Server) sendStartupBroadcast() {
 	log.Println("Startup function list broadcast sent")
 }
 
-// Start initializes and starts the server
+// Start initializes and starts the server and blocks until it is shut down
 func (s *Server) Start() error {
+	return s.StartContext(context.Background())
+}
+
+// StartContext initializes and starts the server and blocks until ctx is done or
+// Shutdown is called. When ctx ends, the server shuts down gracefully, allowing
+// in-flight requests up to Config.ShutdownTimeout to finish. A server starts once;
+// after Shutdown, StartContext returns ErrServerClosed.
+func (s *Server) StartContext(ctx context.Context) error {
+	s.mu.Lock()
+	switch {
+	case s.closed:
+		s.mu.Unlock()
+		return ErrServerClosed
+	case s.started:
+		s.mu.Unlock()
+		return errAlreadyStarted
+	}
+	s.started = true
+	s.mu.Unlock()
+
+	err := s.start()
+	close(s.ready)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+		defer cancel()
+		return s.Shutdown(shutdownCtx)
+	case <-s.done:
+		return s.shutdownErr
+	}
+}
+
+// start initializes the server, connects it and activates its handlers
+func (s *Server) start() error {
 	log.Printf("Starting server with name: %s", s.config.ServerName)
 
 	// Initialize environment
@@ -198,9 +297,6 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to initialize global state: %w", err)
 	}
 
-	// Register and publish functions
-	s.registerAndPublishFunctions()
-
 	// Register server with workflow server
 	s.registerServer()
 
@@ -211,13 +307,200 @@ func (s *Server) Start() error {
 	handlers.Activate(s.globalState)
 	log.Println("Stream listeners activated, server running...")
 
-	// Block forever
-	select {}
+	// Report capacity once connected and then periodically
+	go handlers.RunHeartbeat(s.heartbeat, s.globalState, s.config.HeartbeatInterval)
+
+	s.running.Store(s.globalState)
+	return nil
+}
+
+// Shutdown gracefully stops the server. It stops taking new events, waits for
+// in-flight handlers to finish until ctx is done, tells the workflow server that
+// this worker is going away and finally closes the communicator.
+// Calling Shutdown more than once returns the result of the first call. Called while
+// StartContext is initializing, it waits for the server to be up before stopping it;
+// called before StartContext, it keeps the server from starting.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	started := s.started
+	s.mu.Unlock()
+	if started {
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx)
+		close(s.done)
+	})
+	return s.shutdownErr
+}
+
+// shutdown performs the actual shutdown sequence
+func (s *Server) shutdown(ctx context.Context) error {
+	if s.globalState == nil {
+		return nil
+	}
+	log.Printf("Shutting down server '%s', draining in-flight requests...", s.globalState.ServerName)
+	s.stopHeartbeat()
+
+	var drainErr error
+	if s.globalState.Dispatcher != nil {
+		if err := s.globalState.Dispatcher.Shutdown(ctx); err != nil {
+			drainErr = fmt.Errorf("failed to drain in-flight requests: %w", err)
+			log.Printf("Shutdown deadline reached before all handlers finished: %v", err)
+			handlers.CancelExecutions(s.globalState, errShuttingDown)
+		}
+	}
+
+	s.deregisterServer()
+
+	if err := s.globalState.WorkflowComm.Close(); err != nil {
+		return fmt.Errorf("failed to close communicator: %w", err)
+	}
+
+	log.Printf("Server '%s' stopped", s.globalState.ServerName)
+	return drainErr
+}
+
+// deregisterServer tells the workflow server that this worker is going away
+func (s *Server) deregisterServer() {
+	event := types.EventMessage{
+		Server:        s.globalState.ServerName,
+		Event:         types.EventClientDeregistration,
+		Text:          "Client deregistration",
+		CorrelationID: "shutdown",
+	}
+	if err := s.globalState.WorkflowComm.SendEvent(&event); err != nil {
+		log.Printf("Failed to send deregistration: %v", err)
+	}
+}
+
+// ConnectionState returns the state of the connection to the workflow server.
+// It is StateConnecting until the server has started.
+func (s *Server) ConnectionState() ConnectionState {
+	gs := s.running.Load()
+	if gs == nil || gs.WorkflowComm == nil {
+		return StateConnecting
+	}
+	return gs.WorkflowComm.State()
+}
+
+// SubscribeConnectionState returns a channel receiving the current connection state and
+// every change, e.g. to drive a readiness probe, and a function ending the subscription.
+// Subscribed before the server has started, the channel receives StateConnecting until
+// the server is up; it receives StateClosed and is closed if the server never starts.
+func (s *Server) SubscribeConnectionState() (<-chan ConnectionState, func()) {
+	if gs := s.running.Load(); gs != nil && gs.WorkflowComm != nil {
+		return gs.WorkflowComm.SubscribeState()
+	}
+	states := make(chan ConnectionState, 1)
+	states <- StateConnecting
+	stop := make(chan struct{})
+	go s.followConnectionState(states, stop)
+	var once sync.Once
+	return states, func() { once.Do(func() { close(stop) }) }
+}
+
+// followConnectionState forwards the connection states to states once the server has
+// started, replacing an unread state with the newer one. It closes states after
+// StateClosed, when the server never starts or once stop is closed.
+func (s *Server) followConnectionState(states chan ConnectionState, stop <-chan struct{}) {
+	defer close(states)
+	publish := func(state ConnectionState) {
+		select {
+		case <-states:
+		default:
+		}
+		states <- state
+	}
+
+	select {
+	case <-s.ready:
+	case <-s.done:
+	case <-stop:
+		return
+	}
+	gs := s.running.Load()
+	if gs == nil || gs.WorkflowComm == nil {
+		publish(StateClosed)
+		return
+	}
+	updates, cancel := gs.WorkflowComm.SubscribeState()
+	defer cancel()
+	for {
+		select {
+		case state, ok := <-updates:
+			if !ok {
+				return
+			}
+			publish(state)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Capacity is the load a worker advertises on registration, heartbeats and server info
+type Capacity = types.Capacity
+
+// Stats reports the worker's load and how many events it refused or discarded
+type Stats struct {
+	QueuedEvents     int    // events waiting for a handler slot
+	InFlight         int    // events being handled
+	RejectedRequests uint64 // function requests refused with ErrorCodeOverloaded
+	DroppedEvents    uint64 // events discarded by the communicator
+
+	PendingRequests    int    // rpc, cache and store requests waiting for a response
+	ExpiredRequests    uint64 // requests whose deadline passed without a response
+	OrphanedResponses  uint64 // responses nobody asked for
+	LateResponses      uint64 // responses arriving after the caller gave up
+	DuplicateResponses uint64 // repeated responses to an answered request
+}
+
+// PendingRequest describes an rpc, cache or store request waiting for its response
+type PendingRequest = correlation.Pending
+
+// Stats returns the current load counters; they are zero until the server has started
+func (s *Server) Stats() Stats {
+	var stats Stats
+	gs := s.running.Load()
+	if gs == nil {
+		return stats
+	}
+	if gs.Dispatcher != nil {
+		d := gs.Dispatcher.Stats()
+		stats.QueuedEvents, stats.InFlight, stats.RejectedRequests = d.Queued, d.InFlight, d.Rejected
+	}
+	if comm, ok := gs.WorkflowComm.(interface{ DroppedEvents() uint64 }); ok {
+		stats.DroppedEvents = comm.DroppedEvents()
+	}
+	if gs.Correlations != nil {
+		c := gs.Correlations.Stats()
+		stats.PendingRequests, stats.ExpiredRequests = c.Pending, c.Expired
+		stats.OrphanedResponses, stats.LateResponses, stats.DuplicateResponses = c.Orphaned, c.Late, c.Duplicate
+	}
+	return stats
+}
+
+// PendingRequests lists the rpc, cache and store requests still waiting for a response,
+// oldest first, to debug calls that hang
+func (s *Server) PendingRequests() []PendingRequest {
+	gs := s.running.Load()
+	if gs == nil || gs.Correlations == nil {
+		return nil
+	}
+	return gs.Correlations.Pending()
 }
 
-// GetGlobalState returns the global state for advanced use cases
+// GetGlobalState returns the global state for advanced use cases; it is nil until the
+// server has started
 func (s *Server) GetGlobalState() *state.GlobalState {
-	return s.globalState
+	return s.running.Load()
 }
 
 // GetConfig returns the current configuration
@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/rpc"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+)
+
+// ErrStreamClosed is returned by Emit once the handler has returned
+var ErrStreamClosed = rpc.ErrStreamClosed
+
+// Emitter sends the chunks of a streaming function to its caller while the handler runs
+type Emitter[Chunk any] struct {
+	writer *rpc.ChunkWriter
+}
+
+// Emit encodes chunk as JSON and sends it as the next function_chunk event. It is safe
+// for concurrent use; chunks are numbered in the order Emit is called.
+func (e *Emitter[Chunk]) Emit(chunk Chunk) error {
+	payload, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chunk: %w", err)
+	}
+	return e.writer.Write(payload)
+}
+
+// StreamingFunction builds a function that emits typed chunks of output, such as LLM
+// tokens, before returning its final output
+type StreamingFunction[In any, Chunk any, Out any] struct {
+	name        string
+	version     string
+	description string
+	handler     func(context.Context, In, *Emitter[Chunk], *types.EventMessage, *state.GlobalState) (Out, error)
+	tags        []string
+	timeout     time.Duration
+	concurrency int
+	validation  inputValidation
+}
+
+// NewStreamingFunction creates a builder for a function streaming chunks of type Chunk
+func NewStreamingFunction[In any, Chunk any, Out any](name, version, description string) *StreamingFunction[In, Chunk, Out] {
+	return &StreamingFunction[In, Chunk, Out]{
+		name:        name,
+		version:     version,
+		description: description,
+		tags:        []string{},
+	}
+}
+
+// WithHandler sets the streaming handler. It receives a context that is cancelled like
+// a context handler's, the typed input and an Emitter for its chunks. The stream ends
+// when the handler returns, right before its output or error is sent.
+func (f *StreamingFunction[In, Chunk, Out]) WithHandler(handler func(context.Context, In, *Emitter[Chunk]) (Out, error)) *StreamingFunction[In, Chunk, Out] {
+	f.handler = func(ctx context.Context, in In, emitter *Emitter[Chunk], _ *types.EventMessage, _ *state.GlobalState) (Out, error) {
+		return handler(ctx, in, emitter)
+	}
+	return f
+}
+
+// WithEventHandler sets a streaming handler that also receives the event message and global state
+func (f *StreamingFunction[In, Chunk, Out]) WithEventHandler(handler func(context.Context, In, *Emitter[Chunk], *types.EventMessage, *state.GlobalState) (Out, error)) *StreamingFunction[In, Chunk, Out] {
+	f.handler = handler
+	return f
+}
+
+// WithTags adds tags to the function
+func (f *StreamingFunction[In, Chunk, Out]) WithTags(tags ...string) *StreamingFunction[In, Chunk, Out] {
+	f.tags = append(f.tags, tags...)
+	return f
+}
+
+// WithTimeout limits how long a single execution may run. A value of 0 disables the limit.
+func (f *StreamingFunction[In, Chunk, Out]) WithTimeout(timeout time.Duration) *StreamingFunction[In, Chunk, Out] {
+	f.timeout = timeout
+	return f
+}
+
+// WithMaxConcurrency limits how many executions of this function run at once. A value of 0 disables the limit.
+func (f *StreamingFunction[In, Chunk, Out]) WithMaxConcurrency(n int) *StreamingFunction[In, Chunk, Out] {
+	f.concurrency = n
+	return f
+}
+
+// AllowUnknownFields accepts inputs containing fields that are not part of the input type
+func (f *StreamingFunction[In, Chunk, Out]) AllowUnknownFields() *StreamingFunction[In, Chunk, Out] {
+	f.validation.allowUnknownFields = true
+	return f
+}
+
+// WithoutInputValidation passes inputs to the handler without checking them against the input schema
+func (f *StreamingFunction[In, Chunk, Out]) WithoutInputValidation() *StreamingFunction[In, Chunk, Out] {
+	f.validation.disabled = true
+	return f
+}
+
+// Build creates the actual function implementation. Its definition publishes the
+// schema of the chunks under chunk_schema.
+func (f *StreamingFunction[In, Chunk, Out]) Build(gs *state.GlobalState) basefunction.FunctionInterface {
+	bf := basefunction.NewFunctionWithContext(
+		f.name,
+		f.version,
+		f.description,
+		func(ctx context.Context, inputs In, eventState *types.EventMessage) (Out, error) {
+			writer := rpc.NewChunkWriter(ctx)
+			defer writer.Close()
+			return f.handler(ctx, inputs, &Emitter[Chunk]{writer: writer}, eventState, gs)
+		},
+		f.tags,
+	)
+	bf.SetChunkType(reflect.TypeFor[Chunk]())
+	bf.SetTimeout(f.timeout)
+	bf.SetMaxConcurrency(f.concurrency)
+	f.validation.apply(bf)
+	return bf
+}
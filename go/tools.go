@@ -0,0 +1,19 @@
+package worker
+
+import (
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/models"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/tools"
+)
+
+// Toolset exposes the worker's registered functions as LLM tools.
+// Pass Toolset.LLMTools() to llms.WithTools and run the model's tool calls with Toolset.Call.
+type Toolset = tools.Toolset
+
+// ToolDefinition is an OpenAI-style function tool specification
+type ToolDefinition = models.ToolDefinition
+
+// NewToolset builds a tool for every function registered with the worker
+func NewToolset(gs *state.GlobalState) *Toolset {
+	return tools.NewToolset(gs)
+}
@@ -0,0 +1,225 @@
+package workertest_test
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	worker "github.com/FatsharkStudiosAB/haja-workers/go"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/FatsharkStudiosAB/haja-workers/go/workertest"
+)
+
+// startWorker starts w against srv until the test ends and waits for its registration
+func startWorker(t *testing.T, srv *workertest.Server, w *worker.Server, name string) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	go w.StartContext(ctx)
+	if _, err := srv.WaitForWorker(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+// eventually polls cond until it holds, failing the test when ctx ends first
+func eventually(t *testing.T, ctx context.Context, what string, cond func() bool) {
+	t.Helper()
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("gave up waiting for %s", what)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+// request sends a function_request with a known correlation ID and reports its outcome
+func request(ctx context.Context, srv *workertest.Server, name, function, correlationID string, meta map[string]any) <-chan error {
+	result := make(chan error, 1)
+	payload := []byte(`{"n":1}`)
+	go func() {
+		response, err := srv.Request(ctx, name, &types.EventMessage{
+			Function:      function,
+			Version:       "1.0.0",
+			Server:        workertest.ServerName,
+			Node:          "workertest-node",
+			Workflow:      "workertest-workflow",
+			Run:           "workertest-run",
+			Event:         types.EventFunctionRequest,
+			Meta:          &meta,
+			Payload:       &payload,
+			CorrelationID: correlationID,
+		})
+		if err == nil && response.Event == types.EventError {
+			err = types.ErrorFromEvent(response)
+		}
+		result <- err
+	}()
+	return result
+}
+
+func TestCancelReachesRunningAndQueuedRequests(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("cancel-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+		worker.WithHeartbeatInterval(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started atomic.Int32
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("wait", "1.0.0", "Waits until cancelled").
+		WithContextHandler(func(ctx context.Context, in numberInput) (numberOutput, error) {
+			started.Add(1)
+			<-ctx.Done()
+			return numberOutput{}, context.Cause(ctx)
+		}).
+		WithMaxConcurrency(1))
+	ctx := startWorker(t, srv, w, "cancel-worker")
+
+	running := request(ctx, srv, "cancel-worker", "wait", "running", map[string]any{})
+	eventually(t, ctx, "the first execution", func() bool { return started.Load() == 1 })
+	queued := request(ctx, srv, "cancel-worker", "wait", "queued", map[string]any{})
+	eventually(t, ctx, "the second request to queue", func() bool {
+		capacity, _ := srv.Capacity("cancel-worker")
+		return capacity.QueueDepth == 1
+	})
+
+	// The queued request is cancelled first, so it must not run once the slot frees up
+	for _, correlationID := range []string{"queued", "running"} {
+		if err := srv.Send("cancel-worker", &types.EventMessage{Event: types.EventFunctionCancel, Workflow: "workertest-workflow", CorrelationID: correlationID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-running; types.ErrorCodeOf(err) != types.ErrorCodeCancelled {
+		t.Fatalf("expected the running request to be cancelled, got %v", err)
+	}
+	if err := <-queued; types.ErrorCodeOf(err) != types.ErrorCodeCancelled {
+		t.Fatalf("expected the queued request to be cancelled, got %v", err)
+	}
+	if n := started.Load(); n != 1 {
+		t.Fatalf("the cancelled queued request ran, %d executions started", n)
+	}
+}
+
+func TestTimeoutAnswersRightAwayButHoldsTheSlot(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("timeout-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var running, overlapped atomic.Int32
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("stubborn", "1.0.0", "Ignores its context").
+		WithHandler(func(in numberInput) (numberOutput, error) {
+			if running.Add(1) > 1 {
+				overlapped.Add(1)
+			}
+			defer running.Add(-1)
+			time.Sleep(300 * time.Millisecond)
+			return numberOutput(in), nil
+		}).
+		WithTimeout(50 * time.Millisecond).
+		WithMaxConcurrency(1))
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("patient", "1.0.0", "Waits for its context").
+		WithContextHandler(func(ctx context.Context, in numberInput) (numberOutput, error) {
+			<-ctx.Done()
+			return numberOutput{}, ctx.Err()
+		}))
+	ctx := startWorker(t, srv, w, "timeout-worker")
+
+	// The timeout error arrives long before the handler returns, with the deadline in its details
+	start := time.Now()
+	first := request(ctx, srv, "timeout-worker", "stubborn", "first", map[string]any{})
+	eventually(t, ctx, "the first execution", func() bool { return running.Load() == 1 })
+	second := request(ctx, srv, "timeout-worker", "stubborn", "second", map[string]any{})
+	err = <-first
+	if sdkErr := types.AsError(err); sdkErr == nil || sdkErr.Code != types.ErrorCodeTimeout || !strings.Contains(string(sdkErr.Details), types.MetaDeadline) {
+		t.Fatalf("expected a timeout error with its deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Fatalf("the timeout took %v to arrive", elapsed)
+	}
+	if err := <-second; types.ErrorCodeOf(err) != types.ErrorCodeTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	// The abandoned handlers still counted towards the limit of one
+	if n := overlapped.Load(); n != 0 {
+		t.Fatalf("%d executions ran past the concurrency limit", n)
+	}
+
+	// Deadlines sent by the caller, as RFC 3339 or Unix milliseconds, end the execution too
+	for _, deadline := range []any{
+		time.Now().Add(50 * time.Millisecond).UTC().Format(time.RFC3339Nano),
+		float64(time.Now().Add(50 * time.Millisecond).UnixMilli()),
+	} {
+		err := <-request(ctx, srv, "timeout-worker", "patient", "deadline", map[string]any{types.MetaDeadline: deadline})
+		if types.ErrorCodeOf(err) != types.ErrorCodeTimeout {
+			t.Fatalf("expected a timeout for deadline %v, got %v", deadline, err)
+		}
+	}
+}
+
+func TestPanickingFunctionIsDisabled(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("panic-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+		worker.WithMaxFunctionPanics(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("fragile", "1.0.0", "Panics on negative numbers").
+		WithHandler(func(in numberInput) (numberOutput, error) {
+			if in.N < 0 {
+				panic("negative input")
+			}
+			return numberOutput(in), nil
+		}))
+	ctx := startWorker(t, srv, w, "panic-worker")
+	call := func(n int) error {
+		_, err := srv.CallFunction(ctx, "panic-worker", "fragile", "1.0.0", numberInput{N: n})
+		return err
+	}
+	published := func() bool {
+		for _, def := range srv.Functions("panic-worker") {
+			if def.Name == "fragile" {
+				return true
+			}
+		}
+		return false
+	}
+
+	// A success in between resets the count of consecutive panics
+	for _, n := range []int{-1, 1, -1} {
+		if err := call(n); n < 0 {
+			if sdkErr := types.AsError(err); sdkErr == nil || sdkErr.Code != types.ErrorCodeInternal || !strings.Contains(string(sdkErr.Details), "negative input") {
+				t.Fatalf("expected an internal error carrying the panic, got %v", err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !published() {
+		t.Fatal("fragile was disabled before panicking twice in a row")
+	}
+
+	if err := call(-1); types.ErrorCodeOf(err) != types.ErrorCodeInternal {
+		t.Fatalf("expected an internal error, got %v", err)
+	}
+	eventually(t, ctx, "the function list without fragile", func() bool { return !published() })
+	err = call(1)
+	if sdkErr := types.AsError(err); sdkErr == nil || sdkErr.Code != types.ErrorCodeUnavailable || !strings.Contains(sdkErr.Message, "disabled after 2 consecutive panics") {
+		t.Fatalf("expected requests to the disabled function to fail as unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,532 @@
+// Package workertest provides an in-process workflow server for testing workers.
+//
+// The server speaks the GrpcEventMessage protocol on a localhost listener. It records
+// worker registrations and function lists, answers cache and store requests from
+// in-memory maps, routes function requests between connected workers, runs flows
+// registered with HandleFlow and lets a test call functions and wait for their response:
+//
+//	srv := workertest.NewServer(t)
+//	w, _ := worker.New(worker.WithServerName("my-worker"), worker.WithGrpcServerAddress(srv.Addr()))
+//	w.RegisterFunction(myFunction)
+//	go w.StartContext(ctx)
+//
+//	srv.WaitForWorker(ctx, "my-worker")
+//	output, err := srv.CallFunction(ctx, "my-worker", "my_function", "1.0.0", input)
+package workertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/utils"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/workflowsgrpc"
+
+	"google.golang.org/grpc"
+)
+
+// ServerName is the server name the mock uses for requests it originates
+const ServerName = "workertest"
+
+// Server is an in-memory workflow server
+type Server struct {
+	workflowsgrpc.UnimplementedEventServiceServer
+
+	listener   net.Listener
+	grpcServer *grpc.Server
+
+	mu            sync.Mutex
+	changed       chan struct{} // closed and replaced whenever the recorded state changes
+	workers       map[string]*connection
+	registrations []string
+	functions     map[string][]basefunction.FunctionDefinition
+	capacity      map[string]types.Capacity // last capacity advertised per worker
+	events        []types.EventMessage
+	cache         map[string]cacheEntry
+	store         map[string]map[string][]byte
+	waiters       map[string]chan *types.EventMessage // correlation ID -> caller waiting in Request
+	forwarded     map[string]*connection              // correlation ID -> worker that sent the request
+	flows         map[string]FlowHandler              // workflow ID -> flow started by flow_node_request
+}
+
+// FlowHandler plays a flow started by a flow_node_request. It receives the inputs sent
+// by the worker, may report progress with status, which the worker receives as
+// status_message events, and returns the outputs of the run or an error.
+type FlowHandler func(inputs []byte, status func(text string)) ([]byte, error)
+
+// cacheEntry is a cached value with an optional expiry
+type cacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// connection is one worker's event stream
+type connection struct {
+	name   string
+	mu     sync.Mutex
+	stream grpc.BidiStreamingServer[workflowsgrpc.GrpcEventMessage, workflowsgrpc.GrpcEventMessage]
+}
+
+func (c *connection) send(event *types.EventMessage) error {
+	msg, err := workflowsgrpc.ConvertToGRPC(event)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stream.Send(msg)
+}
+
+// NewServer starts a server on a free localhost port and stops it when the test ends
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("workertest: failed to listen: %v", err)
+	}
+
+	s := &Server{
+		listener:   listener,
+		grpcServer: grpc.NewServer(),
+		changed:    make(chan struct{}),
+		workers:    map[string]*connection{},
+		functions:  map[string][]basefunction.FunctionDefinition{},
+		capacity:   map[string]types.Capacity{},
+		cache:      map[string]cacheEntry{},
+		store:      map[string]map[string][]byte{},
+		waiters:    map[string]chan *types.EventMessage{},
+		forwarded:  map[string]*connection{},
+		flows:      map[string]FlowHandler{},
+	}
+	workflowsgrpc.RegisterEventServiceServer(s.grpcServer, s)
+	go s.grpcServer.Serve(listener)
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the address workers should connect to
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and drops all worker connections
+func (s *Server) Close() {
+	s.grpcServer.Stop()
+}
+
+// Events implements workflowsgrpc.EventServiceServer
+func (s *Server) Events(stream grpc.BidiStreamingServer[workflowsgrpc.GrpcEventMessage, workflowsgrpc.GrpcEventMessage]) error {
+	conn := &connection{stream: stream}
+	defer s.disconnect(conn)
+
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		event, err := workflowsgrpc.ConvertFromGRPC(msg)
+		if err != nil {
+			continue
+		}
+		s.handle(conn, event)
+	}
+}
+
+// handle records an event from a worker and answers it where the protocol expects an answer
+func (s *Server) handle(conn *connection, event *types.EventMessage) {
+	s.mu.Lock()
+	s.events = append(s.events, *event)
+	var reply *types.EventMessage
+	var target *connection
+
+	switch event.Event {
+	case types.EventClientRegistration:
+		conn.name = event.Server
+		s.workers[event.Server] = conn
+		s.registrations = append(s.registrations, event.Server)
+		if capacity, ok := types.CapacityFromMeta(event.Meta); ok {
+			s.capacity[event.Server] = capacity
+		}
+		// Ask for the function list, the worker may have published it before connecting
+		reply = &types.EventMessage{
+			Server:        ServerName,
+			Event:         types.EventRequestListFunctions,
+			CorrelationID: utils.UID(),
+		}
+
+	case types.EventHeartbeat:
+		if capacity, ok := types.CapacityFromMeta(event.Meta); ok {
+			s.capacity[event.Server] = capacity
+		}
+
+	case types.EventClientDeregistration:
+		if s.workers[event.Server] == conn {
+			delete(s.workers, event.Server)
+		}
+
+	case types.EventResponseListFunctions:
+		var definitions []basefunction.FunctionDefinition
+		if event.Payload != nil && json.Unmarshal(*event.Payload, &definitions) == nil {
+			s.functions[event.Server] = definitions
+		}
+
+	case types.EventCacheGetRequest:
+		key := metaString(event, "Key")
+		reply = &types.EventMessage{Event: types.EventCacheGetResponse, CorrelationID: event.CorrelationID}
+		if entry, ok := s.cache[key]; ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+			value := entry.value
+			reply.Payload = &value
+		}
+
+	case types.EventCacheSet:
+		entry := cacheEntry{value: payload(event)}
+		if ttl, err := strconv.ParseFloat(metaString(event, "TTL"), 64); err == nil && ttl > 0 {
+			entry.expires = time.Now().Add(time.Duration(ttl * float64(time.Second)))
+		}
+		s.cache[metaString(event, "Key")] = entry
+		reply = &types.EventMessage{Event: types.EventCacheSetResponse, CorrelationID: event.CorrelationID}
+
+	case types.EventStoreGetRequest:
+		reply = &types.EventMessage{Event: types.EventStoreGetResponse, Workflow: event.Workflow, CorrelationID: event.CorrelationID}
+		if value, ok := s.store[storeWorkflow(event)][metaString(event, "Key")]; ok {
+			reply.Payload = &value
+		}
+
+	case types.EventStoreSetRequest:
+		workflow := storeWorkflow(event)
+		if s.store[workflow] == nil {
+			s.store[workflow] = map[string][]byte{}
+		}
+		s.store[workflow][metaString(event, "Key")] = payload(event)
+		reply = &types.EventMessage{Event: types.EventStoreSetResponse, Workflow: event.Workflow, CorrelationID: event.CorrelationID}
+
+	case types.EventFunctionRequest:
+		// Route calls between workers; the response is forwarded back to the caller
+		if worker, ok := s.workers[event.Server]; ok {
+			s.forwarded[event.CorrelationID] = conn
+			target = worker
+			reply = event
+		} else {
+			sdkErr := types.Errorf(types.ErrorCodeNotFound, "workertest: no worker named %q is connected", event.Server)
+			reply = &types.EventMessage{Event: types.EventError, Text: sdkErr.Message, CorrelationID: event.CorrelationID}
+			sdkErr.ToEvent(reply)
+		}
+
+	case types.EventFlowNodeRequest:
+		if flow, ok := s.flows[metaString(event, types.MetaFlow)]; ok {
+			go s.runFlow(conn, *event, flow)
+		} else {
+			sdkErr := types.Errorf(types.ErrorCodeNotFound, "workertest: no flow named %q is registered", metaString(event, types.MetaFlow))
+			reply = &types.EventMessage{Event: types.EventError, Text: sdkErr.Message, CorrelationID: event.CorrelationID}
+			sdkErr.ToEvent(reply)
+		}
+
+	case types.EventFunctionChunk, types.EventStatusMessage:
+		// Progress of a routed call goes to the caller ahead of the response
+		if caller, ok := s.forwarded[event.CorrelationID]; ok {
+			target = caller
+			reply = event
+		}
+
+	case types.EventFunctionResponse, types.EventError:
+		if caller, ok := s.forwarded[event.CorrelationID]; ok {
+			delete(s.forwarded, event.CorrelationID)
+			target = caller
+			reply = event
+		} else if waiter, ok := s.waiters[event.CorrelationID]; ok {
+			delete(s.waiters, event.CorrelationID)
+			waiter <- event
+		}
+	}
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+
+	if reply == nil {
+		return
+	}
+	if target == nil {
+		target = conn
+	}
+	target.send(reply)
+}
+
+// runFlow runs a flow for a flow_node_request and answers it on the requesting stream
+func (s *Server) runFlow(conn *connection, request types.EventMessage, flow FlowHandler) {
+	workflow := metaString(&request, types.MetaFlow)
+	run := utils.UID()
+	status := func(text string) {
+		conn.send(&types.EventMessage{Event: types.EventStatusMessage, Workflow: workflow, Run: run, Text: text, CorrelationID: request.CorrelationID})
+	}
+
+	outputs, err := flow(payload(&request), status)
+	if err != nil {
+		sdkErr := types.AsError(err)
+		if sdkErr == nil {
+			sdkErr = types.NewError(types.ErrorCodeInternal, err.Error())
+		}
+		reply := &types.EventMessage{Event: types.EventError, Workflow: workflow, Run: run, Text: sdkErr.Message, CorrelationID: request.CorrelationID}
+		sdkErr.ToEvent(reply)
+		conn.send(reply)
+		return
+	}
+	conn.send(&types.EventMessage{Event: types.EventFunctionResponse, Workflow: workflow, Run: run, Payload: &outputs, CorrelationID: request.CorrelationID})
+}
+
+// disconnect forgets a worker whose stream ended
+func (s *Server) disconnect(conn *connection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if conn.name != "" && s.workers[conn.name] == conn {
+		delete(s.workers, conn.name)
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// waitFor blocks until cond, evaluated under the lock, holds or ctx is done
+func (s *Server) waitFor(ctx context.Context, cond func() bool) error {
+	for {
+		s.mu.Lock()
+		ok := cond()
+		changed := s.changed
+		s.mu.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WaitForWorker waits until the named worker has registered and published its function list
+func (s *Server) WaitForWorker(ctx context.Context, name string) ([]basefunction.FunctionDefinition, error) {
+	var definitions []basefunction.FunctionDefinition
+	err := s.waitFor(ctx, func() bool {
+		var published bool
+		definitions, published = s.functions[name]
+		return s.workers[name] != nil && published
+	})
+	if err != nil {
+		return nil, fmt.Errorf("workertest: worker %q did not register: %w", name, err)
+	}
+	return definitions, nil
+}
+
+// Registrations returns the server names of every client_registration received, in order
+func (s *Server) Registrations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.registrations...)
+}
+
+// Functions returns the last function list published by the named worker
+func (s *Server) Functions(name string) []basefunction.FunctionDefinition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]basefunction.FunctionDefinition(nil), s.functions[name]...)
+}
+
+// Capacity returns the capacity the named worker last advertised on registration or heartbeat
+func (s *Server) Capacity(name string) (types.Capacity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	capacity, ok := s.capacity[name]
+	return capacity, ok
+}
+
+// ReceivedEvents returns every event received from workers, in order
+func (s *Server) ReceivedEvents() []types.EventMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]types.EventMessage(nil), s.events...)
+}
+
+// WaitForEvent waits until a worker has sent an event with the given name and returns the first one
+func (s *Server) WaitForEvent(ctx context.Context, event string) (types.EventMessage, error) {
+	var found types.EventMessage
+	err := s.waitFor(ctx, func() bool {
+		for _, e := range s.events {
+			if e.Event == event {
+				found = e
+				return true
+			}
+		}
+		return false
+	})
+	return found, err
+}
+
+// Send delivers an event to the named worker
+func (s *Server) Send(name string, event *types.EventMessage) error {
+	s.mu.Lock()
+	conn, ok := s.workers[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("workertest: no worker named %q is connected", name)
+	}
+	return conn.send(event)
+}
+
+// Request sends an event to the named worker and waits for the function_response or
+// error event with the same correlation ID. A correlation ID is generated if missing.
+func (s *Server) Request(ctx context.Context, name string, event *types.EventMessage) (*types.EventMessage, error) {
+	if event.CorrelationID == "" {
+		event.CorrelationID = utils.UID()
+	}
+	response := make(chan *types.EventMessage, 1)
+	s.mu.Lock()
+	s.waiters[event.CorrelationID] = response
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiters, event.CorrelationID)
+		s.mu.Unlock()
+	}()
+
+	if err := s.Send(name, event); err != nil {
+		return nil, err
+	}
+	select {
+	case msg := <-response:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// CallFunction sends a function_request to the named worker and returns the response
+// payload. input is encoded as JSON unless it is already a []byte or json.RawMessage.
+// An error event is returned as a *types.Error. The deadline of ctx is sent along.
+func (s *Server) CallFunction(ctx context.Context, name, function, version string, input any) ([]byte, error) {
+	var payload []byte
+	switch in := input.(type) {
+	case []byte:
+		payload = in
+	case json.RawMessage:
+		payload = in
+	default:
+		var err error
+		if payload, err = json.Marshal(input); err != nil {
+			return nil, fmt.Errorf("workertest: failed to marshal input: %w", err)
+		}
+	}
+
+	meta := map[string]any{"calling_server": ServerName}
+	if deadline, ok := ctx.Deadline(); ok {
+		meta[types.MetaDeadline] = deadline.UTC().Format(time.RFC3339Nano)
+	}
+
+	response, err := s.Request(ctx, name, &types.EventMessage{
+		Function: function,
+		Version:  version,
+		Server:   ServerName,
+		Node:     "workertest-node",
+		Workflow: "workertest-workflow",
+		Run:      utils.UID(),
+		Event:    types.EventFunctionRequest,
+		Meta:     &meta,
+		Payload:  &payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Event == types.EventError {
+		return nil, types.ErrorFromEvent(response)
+	}
+	if response.Payload == nil {
+		return nil, errors.New("workertest: empty function_response payload")
+	}
+	return *response.Payload, nil
+}
+
+// HandleFlow registers the flow a flow_node_request for workflowID starts
+func (s *Server) HandleFlow(workflowID string, flow FlowHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flows[workflowID] = flow
+}
+
+// CacheValue returns the cached value for key
+func (s *Server) CacheValue(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.cache[key]
+	if !ok || (!entry.expires.IsZero() && time.Now().After(entry.expires)) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// SetCacheValue seeds the cache
+func (s *Server) SetCacheValue(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[key] = cacheEntry{value: value}
+}
+
+// StoreValue returns the stored value for key in the given workflow
+func (s *Server) StoreValue(workflow, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.store[workflow][key]
+	return value, ok
+}
+
+// SetStoreValue seeds the store
+func (s *Server) SetStoreValue(workflow, key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store[workflow] == nil {
+		s.store[workflow] = map[string][]byte{}
+	}
+	s.store[workflow][key] = value
+}
+
+// metaString reads a meta value as a string; numbers are formatted without exponent
+func metaString(event *types.EventMessage, key string) string {
+	if event.Meta == nil {
+		return ""
+	}
+	switch value := (*event.Meta)[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// storeWorkflow returns the workflow a store request refers to
+func storeWorkflow(event *types.EventMessage) string {
+	if event.Workflow != "" {
+		return event.Workflow
+	}
+	return metaString(event, "Workflow")
+}
+
+func payload(event *types.EventMessage) []byte {
+	if event.Payload == nil {
+		return nil
+	}
+	return *event.Payload
+}
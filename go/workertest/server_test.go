@@ -0,0 +1,676 @@
+package workertest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	worker "github.com/FatsharkStudiosAB/haja-workers/go"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/basefunction"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/dispatcher"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/state"
+	"github.com/FatsharkStudiosAB/haja-workers/go/internal/types"
+	"github.com/FatsharkStudiosAB/haja-workers/go/workertest"
+)
+
+type counterInput struct {
+	Key string `json:"key" validate:"min=1"`
+}
+
+type counterOutput struct {
+	Previous string `json:"previous"`
+}
+
+func TestWorkerAgainstMockServer(t *testing.T) {
+	srv := workertest.NewServer(t)
+	srv.SetStoreValue("workertest-workflow", "greeting", []byte("hello"))
+
+	w, err := worker.New(
+		worker.WithServerName("test-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RegisterFunction(worker.NewFunction[counterInput, counterOutput]("remember", "1.0.0", "Stores a key").
+		WithContextHandler(func(ctx context.Context, in counterInput, event *types.EventMessage, gs *state.GlobalState) (counterOutput, error) {
+			previous, err := gs.GrpcStore.GetString(ctx, event.Workflow, in.Key)
+			if err != nil {
+				return counterOutput{}, err
+			}
+			if err := gs.GrpcStore.SetString(ctx, event.Workflow, in.Key, "updated"); err != nil {
+				return counterOutput{}, err
+			}
+			if err := gs.GrpcCache.SetByString(ctx, in.Key, []byte("cached"), 60); err != nil {
+				return counterOutput{}, err
+			}
+			return counterOutput{Previous: previous}, nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	stopped := make(chan error, 1)
+	go func() { stopped <- w.StartContext(workerCtx) }()
+
+	functions, err := srv.WaitForWorker(ctx, "test-worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(functions) != 1 || functions[0].Name != "remember" {
+		t.Fatalf("unexpected function list: %+v", functions)
+	}
+	if _, err := srv.WaitForEvent(ctx, types.EventHeartbeat); err != nil {
+		t.Fatalf("expected a heartbeat: %v", err)
+	}
+	if capacity, ok := srv.Capacity("test-worker"); !ok || capacity.HandlersConcurrency != 8 || capacity.QueueCapacity != 100 {
+		t.Fatalf("unexpected capacity %+v", capacity)
+	}
+
+	output, err := srv.CallFunction(ctx, "test-worker", "remember", "1.0.0", counterInput{Key: "greeting"})
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if string(output) != `{"previous":"hello"}` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+	if value, _ := srv.StoreValue("workertest-workflow", "greeting"); string(value) != "updated" {
+		t.Fatalf("expected the store to be updated, got %q", value)
+	}
+	if value, _ := srv.CacheValue("greeting"); string(value) != "cached" {
+		t.Fatalf("expected the cache to be updated, got %q", value)
+	}
+
+	_, err = srv.CallFunction(ctx, "test-worker", "remember", "1.0.0", counterInput{})
+	if types.ErrorCodeOf(err) != types.ErrorCodeInvalidInput {
+		t.Fatalf("expected invalid_input, got %v", err)
+	}
+	// The set acknowledgements arrived ahead of that response and were expected
+	if stats := w.Stats(); stats.OrphanedResponses != 0 {
+		t.Fatalf("expected no orphaned responses, got %+v", stats)
+	}
+
+	stopWorker()
+	if err := <-stopped; err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if _, err := srv.WaitForEvent(ctx, types.EventClientDeregistration); err != nil {
+		t.Fatalf("expected a deregistration: %v", err)
+	}
+}
+
+// The capacity is part of the very first registration, not only of later heartbeats
+func TestFirstRegistrationCarriesCapacity(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("capacity-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+		worker.WithHeartbeatInterval(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("limited", "1.0.0", "Runs two at a time").
+		WithHandler(func(in numberInput) (numberOutput, error) { return numberOutput(in), nil }).
+		WithMaxConcurrency(2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	defer stopWorker()
+	go w.StartContext(workerCtx)
+
+	registration, err := srv.WaitForEvent(ctx, types.EventClientRegistration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capacity, ok := types.CapacityFromMeta(registration.Meta)
+	if !ok || capacity.HandlersConcurrency != 8 || capacity.QueueCapacity != 100 || capacity.FunctionConcurrency["limited:1.0.0"] != 2 {
+		t.Fatalf("expected the capacity in the first registration, got %+v", registration.Meta)
+	}
+}
+
+// A handler waiting for a store response must not keep the response from being
+// delivered, even when it occupies the only handler slot and every control worker,
+// which would route the response otherwise, is busy too
+func TestResponsesReachHandlersBlockingEveryWorker(t *testing.T) {
+	srv := workertest.NewServer(t)
+	srv.SetStoreValue("workertest-workflow", "greeting", []byte("hello"))
+
+	w, err := worker.New(
+		worker.WithServerName("busy-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+		func(c *worker.Config) {
+			c.HandlersConcurrency = 1
+			c.IncomingEventsBuffer = 1
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := &listingGate{release: make(chan struct{})}
+	w.RegisterFunction(gatedBuilder{gate: gate, FunctionBuilder: worker.NewFunction[counterInput, counterOutput]("read", "1.0.0", "Reads a key").
+		WithContextHandler(func(ctx context.Context, in counterInput, event *types.EventMessage, gs *state.GlobalState) (counterOutput, error) {
+			previous, err := gs.GrpcStore.GetString(ctx, event.Workflow, in.Key)
+			return counterOutput{Previous: previous}, err
+		})})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	defer stopWorker()
+	defer close(gate.release)
+	go w.StartContext(workerCtx)
+
+	if _, err := srv.WaitForWorker(ctx, "busy-worker"); err != nil {
+		t.Fatal(err)
+	}
+	// Listing the functions blocks on the gate, keeping every control worker busy
+	gate.armed.Store(true)
+	for i := 0; i < dispatcher.ControlWorkers; i++ {
+		if err := srv.Send("busy-worker", &types.EventMessage{Server: workertest.ServerName, Event: types.EventRequestListFunctions, CorrelationID: fmt.Sprintf("list-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for gate.blocked.Load() < dispatcher.ControlWorkers {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("only %d control workers are listing functions", gate.blocked.Load())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		output, err := srv.CallFunction(ctx, "busy-worker", "read", "1.0.0", counterInput{Key: "greeting"})
+		if err != nil {
+			t.Fatalf("call %d failed: %v", i, err)
+		}
+		if string(output) != `{"previous":"hello"}` {
+			t.Fatalf("unexpected output: %s", output)
+		}
+	}
+}
+
+// listingGate holds callers of GetFunctionDefinition once armed, until release is closed
+type listingGate struct {
+	armed   atomic.Bool
+	blocked atomic.Int32
+	release chan struct{}
+}
+
+// gatedBuilder builds a function whose definition is only handed out through its gate
+type gatedBuilder struct {
+	worker.FunctionBuilder
+	gate *listingGate
+}
+
+func (b gatedBuilder) Build(gs *state.GlobalState) basefunction.FunctionInterface {
+	return gatedFunction{FunctionInterface: b.FunctionBuilder.Build(gs), gate: b.gate}
+}
+
+type gatedFunction struct {
+	basefunction.FunctionInterface
+	gate *listingGate
+}
+
+func (f gatedFunction) GetFunctionDefinition() basefunction.FunctionDefinition {
+	if f.gate.armed.Load() {
+		f.gate.blocked.Add(1)
+		<-f.gate.release
+	}
+	return f.FunctionInterface.GetFunctionDefinition()
+}
+
+func TestShutdownBeforeStartKeepsTheServerFromStarting(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(worker.WithServerName("early-worker"), worker.WithGrpcServerAddress(srv.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, cancel := w.SubscribeConnectionState()
+	defer cancel()
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.StartContext(context.Background()); !errors.Is(err, worker.ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+	if registrations := srv.Registrations(); len(registrations) != 0 {
+		t.Fatalf("the closed server registered as %v", registrations)
+	}
+	if last := lastState(t, states); last != worker.StateClosed {
+		t.Fatalf("expected the subscription to end with %s, got %s", worker.StateClosed, last)
+	}
+}
+
+func TestSubscribeConnectionStateBeforeStart(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("watched-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, cancel := w.SubscribeConnectionState()
+	defer cancel()
+	if state := <-states; state != worker.StateConnecting {
+		t.Fatalf("expected %s before Start, got %s", worker.StateConnecting, state)
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stop()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	stopped := make(chan error, 1)
+	go func() { stopped <- w.StartContext(workerCtx) }()
+	for state := range states {
+		if state == worker.StateConnected {
+			break
+		}
+	}
+
+	stopWorker()
+	if err := <-stopped; err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if last := lastState(t, states); last != worker.StateClosed {
+		t.Fatalf("expected the subscription to end with %s, got %s", worker.StateClosed, last)
+	}
+}
+
+// lastState returns the last state received before states is closed
+func lastState(t *testing.T, states <-chan worker.ConnectionState) worker.ConnectionState {
+	t.Helper()
+	var last worker.ConnectionState
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case state, ok := <-states:
+			if !ok {
+				return last
+			}
+			last = state
+		case <-timeout:
+			t.Fatalf("the subscription was not closed, last state %s", last)
+		}
+	}
+}
+
+func TestShutdownWhileStartingStopsTheServer(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("starting-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Building the function holds StartContext in the middle of initializing
+	building, release := make(chan struct{}), make(chan struct{})
+	w.RegisterFunction(slowBuilder{building: building, release: release, FunctionBuilder: worker.NewSimpleFunction[numberInput, numberOutput]("echo", "1.0.0", "Echoes").
+		WithHandler(func(in numberInput) (numberOutput, error) { return numberOutput(in), nil })})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	started := make(chan error, 1)
+	go func() { started <- w.StartContext(context.Background()) }()
+	select {
+	case <-building:
+	case <-ctx.Done():
+		t.Fatal("the server did not start initializing")
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- w.Shutdown(ctx) }()
+	// Shutdown waits for the server to be up rather than tearing down half of it
+	select {
+	case err := <-stopped:
+		t.Fatalf("Shutdown returned while the server was starting: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	for _, result := range []chan error{stopped, started} {
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-ctx.Done():
+			t.Fatal("the server did not stop")
+		}
+	}
+	if state := w.ConnectionState(); state != worker.StateClosed {
+		t.Fatalf("the server is still %s after Shutdown", state)
+	}
+}
+
+// slowBuilder signals building when Build is called and waits for release
+type slowBuilder struct {
+	worker.FunctionBuilder
+	building chan<- struct{}
+	release  <-chan struct{}
+}
+
+func (b slowBuilder) Build(gs *state.GlobalState) basefunction.FunctionInterface {
+	close(b.building)
+	<-b.release
+	return b.FunctionBuilder.Build(gs)
+}
+
+type numberInput struct {
+	N int `json:"n"`
+}
+
+type numberOutput struct {
+	N int `json:"n"`
+}
+
+func TestInvokeCallsAnotherFunction(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("math-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("double", "1.0.0", "Doubles a number").
+		WithHandler(func(in numberInput) (numberOutput, error) {
+			return numberOutput{N: in.N * 2}, nil
+		}))
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("quadruple", "1.0.0", "Doubles a number twice").
+		WithContextHandler(func(ctx context.Context, in numberInput) (numberOutput, error) {
+			twice, err := worker.Invoke[numberInput, numberOutput](ctx, "math-worker", "double", "1.0.0", in)
+			if err != nil {
+				return numberOutput{}, err
+			}
+			return worker.Invoke[numberInput, numberOutput](ctx, "math-worker", "double", "1.0.0", numberInput{N: twice.N})
+		}))
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("missing", "1.0.0", "Calls an unknown worker").
+		WithContextHandler(func(ctx context.Context, in numberInput) (numberOutput, error) {
+			return worker.Invoke[numberInput, numberOutput](ctx, "nobody", "double", "1.0.0", in)
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	defer stopWorker()
+	go w.StartContext(workerCtx)
+
+	if _, err := srv.WaitForWorker(ctx, "math-worker"); err != nil {
+		t.Fatal(err)
+	}
+	output, err := srv.CallFunction(ctx, "math-worker", "quadruple", "1.0.0", numberInput{N: 3})
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if string(output) != `{"n":12}` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	// The remote error reaches the outer caller with its code intact
+	_, err = srv.CallFunction(ctx, "math-worker", "missing", "1.0.0", numberInput{N: 3})
+	if types.ErrorCodeOf(err) != types.ErrorCodeNotFound {
+		t.Fatalf("expected not_found, got %v", err)
+	}
+}
+
+func TestInvokeAllFansOutCalls(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("fanout-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("square", "1.0.0", "Squares a non-negative number").
+		WithHandler(func(in numberInput) (numberOutput, error) {
+			if in.N < 0 {
+				return numberOutput{}, worker.NewError(worker.ErrorCodeInvalidInput, "negative number")
+			}
+			return numberOutput{N: in.N * in.N}, nil
+		}))
+	w.RegisterFunction(worker.NewSimpleFunction[[]numberInput, []numberOutput]("squares", "1.0.0", "Squares every number").
+		WithContextHandler(func(ctx context.Context, in []numberInput) ([]numberOutput, error) {
+			return worker.InvokeAll[numberInput, numberOutput](ctx, "fanout-worker", "square", "1.0.0", in, worker.BatchOptions{Concurrency: 3})
+		}))
+	w.RegisterFunction(worker.NewSimpleFunction[[]numberInput, int]("failures", "1.0.0", "Counts the numbers that cannot be squared").
+		WithContextHandler(func(ctx context.Context, in []numberInput) (int, error) {
+			failures := 0
+			for result := range worker.InvokeBatch[numberInput, numberOutput](ctx, "fanout-worker", "square", "1.0.0", in, worker.BatchOptions{Mode: worker.CollectErrors}) {
+				if worker.AsError(result.Err) != nil {
+					failures++
+				}
+			}
+			return failures, nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	defer stopWorker()
+	go w.StartContext(workerCtx)
+
+	if _, err := srv.WaitForWorker(ctx, "fanout-worker"); err != nil {
+		t.Fatal(err)
+	}
+	inputs := make([]numberInput, 20)
+	for i := range inputs {
+		inputs[i] = numberInput{N: i}
+	}
+	output, err := srv.CallFunction(ctx, "fanout-worker", "squares", "1.0.0", inputs)
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	var squares []numberOutput
+	if err := json.Unmarshal(output, &squares); err != nil || len(squares) != 20 || squares[19].N != 361 {
+		t.Fatalf("unexpected output %s, %v", output, err)
+	}
+
+	inputs[4].N, inputs[7].N = -1, -2
+	output, err = srv.CallFunction(ctx, "fanout-worker", "failures", "1.0.0", inputs)
+	if err != nil || string(output) != "2" {
+		t.Fatalf("expected 2 failures, got %s, %v", output, err)
+	}
+	_, err = srv.CallFunction(ctx, "fanout-worker", "squares", "1.0.0", inputs)
+	if types.ErrorCodeOf(err) != types.ErrorCodeInvalidInput {
+		t.Fatalf("expected the failing call's invalid_input, got %v", err)
+	}
+}
+
+type pipelineOutput struct {
+	N        int      `json:"n"`
+	Statuses []string `json:"statuses"`
+}
+
+func TestInvokeFlowStreamsStatus(t *testing.T) {
+	srv := workertest.NewServer(t)
+	srv.HandleFlow("double-flow", func(inputs []byte, status func(string)) ([]byte, error) {
+		var in numberInput
+		if err := json.Unmarshal(inputs, &in); err != nil {
+			return nil, err
+		}
+		status("doubling")
+		status("done")
+		return json.Marshal(numberOutput{N: in.N * 2})
+	})
+	w, err := worker.New(
+		worker.WithServerName("flow-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, pipelineOutput]("pipeline", "1.0.0", "Runs a flow").
+		WithContextHandler(func(ctx context.Context, in numberInput) (pipelineOutput, error) {
+			var statuses []string
+			out, err := worker.InvokeFlow[numberInput, numberOutput](ctx, "double-flow", in,
+				worker.WithFlowStatus(func(status worker.FlowStatus) { statuses = append(statuses, status.Text) }))
+			return pipelineOutput{N: out.N, Statuses: statuses}, err
+		}))
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("unknown", "1.0.0", "Runs a missing flow").
+		WithContextHandler(func(ctx context.Context, in numberInput) (numberOutput, error) {
+			return worker.InvokeFlow[numberInput, numberOutput](ctx, "missing-flow", in)
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	defer stopWorker()
+	go w.StartContext(workerCtx)
+
+	if _, err := srv.WaitForWorker(ctx, "flow-worker"); err != nil {
+		t.Fatal(err)
+	}
+	output, err := srv.CallFunction(ctx, "flow-worker", "pipeline", "1.0.0", numberInput{N: 3})
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if string(output) != `{"n":6,"statuses":["doubling","done"]}` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+	request, err := srv.WaitForEvent(ctx, types.EventFlowNodeRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema, ok := (*request.Meta)[types.MetaInputSchema].(map[string]any); !ok || schema["type"] != "object" {
+		t.Fatalf("expected the input schema in the request meta, got %+v", *request.Meta)
+	}
+
+	_, err = srv.CallFunction(ctx, "flow-worker", "unknown", "1.0.0", numberInput{N: 3})
+	if types.ErrorCodeOf(err) != types.ErrorCodeNotFound {
+		t.Fatalf("expected not_found, got %v", err)
+	}
+}
+
+type tokenChunk struct {
+	Text string `json:"text"`
+}
+
+func TestInvokeStreamReceivesChunksInOrder(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("stream-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RegisterFunction(worker.NewStreamingFunction[numberInput, tokenChunk, numberOutput]("count", "1.0.0", "Streams the numbers up to n").
+		WithHandler(func(ctx context.Context, in numberInput, emitter *worker.Emitter[tokenChunk]) (numberOutput, error) {
+			for i := 1; i <= in.N; i++ {
+				if err := emitter.Emit(tokenChunk{Text: fmt.Sprint(i)}); err != nil {
+					return numberOutput{}, err
+				}
+			}
+			return numberOutput{N: in.N}, nil
+		}))
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, []string]("collect", "1.0.0", "Collects the streamed numbers").
+		WithContextHandler(func(ctx context.Context, in numberInput) ([]string, error) {
+			var texts []string
+			out, err := worker.InvokeStream[numberInput, tokenChunk, numberOutput](ctx, "stream-worker", "count", "1.0.0", in,
+				func(chunk tokenChunk) { texts = append(texts, chunk.Text) })
+			if err != nil {
+				return nil, err
+			}
+			return append(texts, fmt.Sprint("total ", out.N)), nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	defer stopWorker()
+	go w.StartContext(workerCtx)
+
+	functions, err := srv.WaitForWorker(ctx, "stream-worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, function := range functions {
+		if (function.Name == "count") != (function.ChunkSchema != nil) {
+			t.Fatalf("only the streaming function should publish a chunk schema: %+v", function)
+		}
+	}
+	output, err := srv.CallFunction(ctx, "stream-worker", "collect", "1.0.0", numberInput{N: 5})
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if string(output) != `["1","2","3","4","5","total 5"]` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	// The stream ends with a final chunk numbered after the last one
+	var last types.EventMessage
+	for _, event := range srv.ReceivedEvents() {
+		if event.Event == types.EventFunctionChunk {
+			last = event
+		}
+	}
+	if chunk, ok := types.ChunkFromMeta(last.Meta); !ok || !chunk.Final || chunk.Sequence != 5 || last.Payload != nil {
+		t.Fatalf("expected a final chunk with sequence 5, got %+v", last)
+	}
+}
+
+// chunklessBuilder marks a function that never sends a chunk as streaming, the way a
+// streaming function looks to its caller when its whole stream is lost
+type chunklessBuilder struct {
+	worker.FunctionBuilder
+}
+
+func (b chunklessBuilder) Build(gs *state.GlobalState) basefunction.FunctionInterface {
+	return chunklessFunction{b.FunctionBuilder.Build(gs)}
+}
+
+type chunklessFunction struct {
+	basefunction.FunctionInterface
+}
+
+func (f chunklessFunction) IsStreaming() bool { return true }
+
+func TestInvokeStreamRequiresTheFinalChunkOfAStreamingFunction(t *testing.T) {
+	srv := workertest.NewServer(t)
+	w, err := worker.New(
+		worker.WithServerName("stream-worker"),
+		worker.WithGrpcServerAddress(srv.Addr()),
+		worker.WithShutdownTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RegisterFunction(chunklessBuilder{worker.NewSimpleFunction[numberInput, numberOutput]("silent", "1.0.0", "Streams without chunks").
+		WithHandler(func(in numberInput) (numberOutput, error) { return numberOutput{N: in.N}, nil })})
+	w.RegisterFunction(worker.NewSimpleFunction[numberInput, numberOutput]("collect", "1.0.0", "Collects the streamed numbers").
+		WithContextHandler(func(ctx context.Context, in numberInput) (numberOutput, error) {
+			return worker.InvokeStream[numberInput, tokenChunk, numberOutput](ctx, "stream-worker", "silent", "1.0.0", in, func(tokenChunk) {})
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	defer stopWorker()
+	go w.StartContext(workerCtx)
+
+	if _, err := srv.WaitForWorker(ctx, "stream-worker"); err != nil {
+		t.Fatal(err)
+	}
+	// Without a single chunk the missing final one is noticed from the response alone
+	_, err = srv.CallFunction(ctx, "stream-worker", "collect", "1.0.0", numberInput{})
+	if types.ErrorCodeOf(err) != types.ErrorCodeInternal || !strings.Contains(err.Error(), "lost the end of the chunk stream") {
+		t.Fatalf("expected the lost final chunk to fail the call, got %v", err)
+	}
+}
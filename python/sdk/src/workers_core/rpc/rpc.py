@@ -8,6 +8,7 @@ from typing import Any, Optional
 from workers_core.correlation.router import Router
 from workers_core.types import events as ev
 from workers_core.types.message import EventMessage
+from workers_core.utils.uid import uid
 
 
 @dataclass
@@ -44,9 +45,7 @@ class RpcClient:
         loop = asyncio.get_running_loop()
         correlation_id = getattr(event_state, "correlation_id", None) or ""
         # generate new correlation id for the call
-        import uuid
-
-        correlation_id = uuid.uuid4().hex
+        correlation_id = uid()
         fut = self.router.register(correlation_id)
 
         if execution_node.type != "flow_tool":
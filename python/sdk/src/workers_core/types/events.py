@@ -6,9 +6,20 @@ EventError = "error"
 EventStatusMessage = "status_message"
 EventClientRegistration = "client_registration"
 
+# Sent by a worker that is shutting down and will not accept new requests
+EventClientDeregistration = "client_deregistration"
+
+# Sent periodically by a worker with its capacity in meta
+EventHeartbeat = "heartbeat"
+
 # Function invocation
 EventFunctionRequest = "function_request"
 EventFunctionResponse = "function_response"
+EventFunctionCancel = "function_cancel"
+
+# Sent by a streaming function for each chunk of output before its response; meta
+# carries the sequence number, and a last chunk with final set ends the stream
+EventFunctionChunk = "function_chunk"
 
 # Flow invocation
 EventFlowNodeRequest = "flow_node_request"
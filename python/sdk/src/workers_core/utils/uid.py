@@ -1,4 +1,15 @@
-from uuid import uuid4
+import os
+import time
+from uuid import UUID
+
 
 def uid() -> str:
-    return uuid4().hex
+    """Return a new UUIDv7 (RFC 9562) in canonical form, e.g. "0190b5e4-3c1a-7d2e-9f40-5b6c7d8e9f01".
+
+    The first 48 bits are the Unix time in milliseconds, so IDs sort by creation time;
+    the remaining 74 bits are random. The Go SDK generates the same format.
+    """
+    value = (time.time_ns() // 1_000_000) << 80 | int.from_bytes(os.urandom(10), "big")
+    value = value & ~(0xF << 76) | 0x7 << 76  # version 7
+    value = value & ~(0x3 << 62) | 0x2 << 62  # RFC 9562 variant
+    return str(UUID(int=value))